@@ -0,0 +1,111 @@
+package audit
+
+import (
+	"sync"
+	"time"
+	"virt-webui/models"
+
+	"github.com/astaxie/beego"
+)
+
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+
+	// DefaultLimit is the number of entries Query returns when the filter sets none
+	DefaultLimit = 100
+)
+
+// Filter selects audit entries. Unset fields match any entry.
+type Filter struct {
+	User     string
+	Resource string
+	Target   string
+	// Since and Until bound the time of the entries, both inclusive
+	Since time.Time
+	Until time.Time
+	Limit int
+}
+
+// Match tells whether entry is selected by f.
+func (f *Filter) Match(entry *models.AuditEntry) bool {
+	return (f.User == "" || entry.User == f.User) &&
+		(f.Resource == "" || entry.Resource == f.Resource) &&
+		(f.Target == "" || entry.Target == f.Target) &&
+		(f.Since.IsZero() || !entry.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || !entry.Time.After(f.Until))
+}
+
+// Sink stores audit entries.
+type Sink interface {
+	Record(entry *models.AuditEntry) error
+	// Query returns the entries selected by filter, the newest first
+	Query(filter Filter) ([]models.AuditEntry, error)
+}
+
+var (
+	mu   sync.RWMutex
+	sink Sink
+)
+
+// Init sets up the sink configured in app.conf: the MySQL database of sqlconn if it is set,
+// else the JSON lines file auditfile. Setting neither disables the audit log.
+func Init() error {
+	var s Sink
+	var err error
+	switch {
+	case beego.AppConfig.String("sqlconn") != "":
+		s, err = NewSQLSink(beego.AppConfig.String("sqlconn"))
+	case beego.AppConfig.String("auditfile") != "":
+		s, err = NewFileSink(beego.AppConfig.String("auditfile"))
+	default:
+		beego.Warning("no audit sink is configured, mutating calls are not recorded")
+	}
+	if err != nil {
+		return err
+	}
+	SetSink(s)
+	return nil
+}
+
+// SetSink makes s the sink of Record and Query, nil disables the audit log.
+func SetSink(s Sink) {
+	mu.Lock()
+	defer mu.Unlock()
+	sink = s
+}
+
+// Enabled tells whether a sink is set.
+func Enabled() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return sink != nil
+}
+
+// Record stores entry in the sink. A failure is logged, the call being audited is done already.
+func Record(entry *models.AuditEntry) {
+	mu.RLock()
+	defer mu.RUnlock()
+	if sink == nil {
+		return
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	if err := sink.Record(entry); err != nil {
+		beego.Error("cannot record audit entry", entry.Resource, entry.Action, entry.Target+":", err)
+	}
+}
+
+// Query returns the entries of the sink selected by filter, the newest first.
+func Query(filter Filter) ([]models.AuditEntry, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	if sink == nil {
+		return nil, nil
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultLimit
+	}
+	return sink.Query(filter)
+}
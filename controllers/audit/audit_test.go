@@ -0,0 +1,72 @@
+package audit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"virt-webui/models"
+)
+
+func TestFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.jsonl")
+	sink, err := NewFileSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetSink(sink)
+	defer SetSink(nil)
+
+	start := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	for n, e := range []models.AuditEntry{
+		{User: "alice", Resource: "VM", Action: "Create", Target: "web"},
+		{User: "bob", Resource: "VM", Action: "Start", Target: "web"},
+		{User: "alice", Resource: "Image", Action: "Delete", Target: "web"},
+		{User: "alice", Resource: "VM", Action: "Stop", Target: "db", Result: ResultFailure, Error: "forbidden"},
+		{User: "alice", Resource: "VM", Action: "Delete", Target: "web"},
+	} {
+		e.Time = start.Add(time.Duration(n) * time.Minute)
+		Record(&e)
+	}
+	// A torn last line must not hide the others
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	f.WriteString(`{"User": "tor`)
+	f.Close()
+
+	for _, c := range []struct {
+		name    string
+		filter  Filter
+		actions []string
+	}{
+		{"all", Filter{}, []string{"Delete", "Stop", "Delete", "Start", "Create"}},
+		{"user", Filter{User: "alice"}, []string{"Delete", "Stop", "Delete", "Create"}},
+		{"vm", Filter{Resource: "VM", Target: "web"}, []string{"Delete", "Start", "Create"}},
+		{"time range", Filter{Since: start.Add(time.Minute), Until: start.Add(3 * time.Minute)}, []string{"Stop", "Delete", "Start"}},
+		{"limit", Filter{User: "alice", Limit: 2}, []string{"Delete", "Stop"}},
+	} {
+		entries, err := Query(c.filter)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		var actions []string
+		for _, e := range entries {
+			actions = append(actions, e.Action)
+		}
+		if len(actions) != len(c.actions) {
+			t.Errorf("%s: got %v, expected %v", c.name, actions, c.actions)
+			continue
+		}
+		for n := range actions {
+			if actions[n] != c.actions[n] {
+				t.Errorf("%s: got %v, expected %v", c.name, actions, c.actions)
+				break
+			}
+		}
+	}
+}
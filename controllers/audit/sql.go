@@ -0,0 +1,108 @@
+package audit
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+	"virt-webui/models"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+const createAuditTable = `CREATE TABLE IF NOT EXISTS audit_log (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	time_us BIGINT NOT NULL,
+	user_name VARCHAR(255) NOT NULL,
+	remote_addr VARCHAR(255) NOT NULL,
+	cluster VARCHAR(255) NOT NULL,
+	namespace VARCHAR(255) NOT NULL,
+	resource VARCHAR(64) NOT NULL,
+	action VARCHAR(64) NOT NULL,
+	target VARCHAR(255) NOT NULL,
+	body_digest VARCHAR(64) NOT NULL,
+	status_code INT NOT NULL,
+	result VARCHAR(16) NOT NULL,
+	error TEXT NOT NULL,
+	transcript MEDIUMTEXT NOT NULL,
+	INDEX (time_us),
+	INDEX (user_name),
+	INDEX (target)
+)`
+
+const auditColumns = "time_us, user_name, remote_addr, cluster, namespace, resource, action, target, body_digest, status_code, result, error, transcript"
+
+// SQLSink stores the entries in the audit_log table of a MySQL database, created if needed.
+// Times are stored as microseconds since the epoch, independent of the time zone of the server.
+type SQLSink struct {
+	db *sql.DB
+}
+
+// NewSQLSink connects to the MySQL database of the go-sql-driver/mysql DSN dsn.
+func NewSQLSink(dsn string) (*SQLSink, error) {
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(createAuditTable); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLSink{db: db}, nil
+}
+
+func (s *SQLSink) Record(entry *models.AuditEntry) error {
+	_, err := s.db.Exec("INSERT INTO audit_log ("+auditColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		entry.Time.UnixNano()/int64(time.Microsecond), entry.User, entry.RemoteAddr, entry.Cluster, entry.Namespace,
+		entry.Resource, entry.Action, entry.Target, entry.BodyDigest, entry.StatusCode, entry.Result, entry.Error, entry.Transcript)
+	return err
+}
+
+func (s *SQLSink) Query(filter Filter) ([]models.AuditEntry, error) {
+	var conditions []string
+	var args []interface{}
+	for _, c := range []struct {
+		column string
+		value  string
+	}{
+		{"user_name", filter.User},
+		{"resource", filter.Resource},
+		{"target", filter.Target},
+	} {
+		if c.value != "" {
+			conditions = append(conditions, c.column+" = ?")
+			args = append(args, c.value)
+		}
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "time_us >= ?")
+		args = append(args, filter.Since.UnixNano()/int64(time.Microsecond))
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "time_us <= ?")
+		args = append(args, filter.Until.UnixNano()/int64(time.Microsecond))
+	}
+	query := "SELECT " + auditColumns + " FROM audit_log"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY time_us DESC, id DESC LIMIT ?"
+	args = append(args, filter.Limit)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []models.AuditEntry
+	for rows.Next() {
+		var entry models.AuditEntry
+		var timeUs int64
+		if err := rows.Scan(&timeUs, &entry.User, &entry.RemoteAddr, &entry.Cluster, &entry.Namespace, &entry.Resource,
+			&entry.Action, &entry.Target, &entry.BodyDigest, &entry.StatusCode, &entry.Result, &entry.Error, &entry.Transcript); err != nil {
+			return nil, err
+		}
+		entry.Time = time.Unix(0, timeUs*int64(time.Microsecond))
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"virt-webui/controllers/audit"
+	"virt-webui/models"
+
+	"github.com/astaxie/beego"
+)
+
+// Operations about the audit log
+type AuditController struct {
+	baseController
+}
+
+// @Title List Audit Entries
+// @Description List the audit log of the mutating VM and image calls, the newest first.
+// @Param	user	query	string	false	"Only the calls of this user"
+// @Param	vm	query	string	false	"Only the calls on this VM"
+// @Param	image	query	string	false	"Only the calls on this image"
+// @Param	since	query	string	false	"Only the calls at or after this RFC 3339 time"
+// @Param	until	query	string	false	"Only the calls at or before this RFC 3339 time"
+// @Param	limit	query	int	false	"The number of entries to return, 100 by default"
+// @Success 200 {object} controllers.JsonResponseListAuditSuccess
+// @Failure 400 Bad audit request.
+// @Failure 403 Not an audit reader.
+// @Failure 500 Failed to list audit entries.
+// @router / [get]
+func (a *AuditController) GetAll() {
+	if !a.isAuditReader() {
+		a.SetError("Failed to list audit entries.", newAPIError(http.StatusForbidden, ErrCodeForbidden, "Only the auditreaders of app.conf may read the audit log."))
+		a.ServeJSON()
+		return
+	}
+
+	filter, err := a.auditFilter()
+	if err != nil {
+		a.SetError("Bad audit request.", badRequest(err))
+		a.ServeJSON()
+		return
+	}
+	entries, err := audit.Query(filter)
+	if err != nil {
+		a.SetError("Failed to list audit entries.", err)
+		a.ServeJSON()
+		return
+	}
+	a.Data["json"] = JsonResponseListAuditSuccess{200, "Audit entries list success.", audit.Enabled(), entries}
+	a.ServeJSON()
+}
+
+type JsonResponseListAuditSuccess struct {
+	StatusCode int
+	Message    string
+	// Enabled is unset when no audit sink is configured
+	Enabled bool
+	Entries []models.AuditEntry
+}
+
+// auditFilter builds the filter of the query parameters of the request.
+func (a *AuditController) auditFilter() (audit.Filter, error) {
+	filter := audit.Filter{User: a.GetString("user")}
+	vm, image := a.GetString("vm"), a.GetString("image")
+	switch {
+	case vm != "" && image != "":
+		return filter, fmt.Errorf("vm and image are mutually exclusive")
+	case vm != "":
+		filter.Resource, filter.Target = "VM", vm
+	case image != "":
+		filter.Resource, filter.Target = "Image", image
+	}
+	for _, bound := range []struct {
+		param string
+		time  *time.Time
+	}{
+		{"since", &filter.Since},
+		{"until", &filter.Until},
+	} {
+		if value := a.GetString(bound.param); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s %s, expected an RFC 3339 time", bound.param, value)
+			}
+			*bound.time = t
+		}
+	}
+	limit, err := a.GetInt("limit", audit.DefaultLimit)
+	if err != nil || limit <= 0 {
+		return filter, fmt.Errorf("invalid limit %s", a.GetString("limit"))
+	}
+	filter.Limit = limit
+	return filter, nil
+}
+
+// isAuditReader tells whether the user of the request is one of the users or groups of
+// auditreaders in app.conf. Nobody is when auditreaders is empty, anyone when authentication is not set up.
+func (a *AuditController) isAuditReader() bool {
+	readers := beego.AppConfig.String("auditreaders")
+	identity := a.Identity()
+	if identity == nil {
+		return true
+	}
+	for _, reader := range strings.Split(readers, ",") {
+		reader = strings.TrimSpace(reader)
+		if reader == "" {
+			continue
+		}
+		if reader == identity.Name {
+			return true
+		}
+		for _, group := range identity.Groups {
+			if reader == group {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,280 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/astaxie/beego"
+	beecontext "github.com/astaxie/beego/context"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	MethodToken = "token"
+	MethodBasic = "basic"
+	MethodOIDC  = "oidc"
+)
+
+// Identity is the authenticated user of a request.
+type Identity struct {
+	Name   string
+	Groups []string
+	// Method is how the user authenticated, MethodToken, MethodBasic or MethodOIDC
+	Method string
+}
+
+// Authenticator authenticates requests by one method.
+type Authenticator interface {
+	// Authenticate returns the identity of r, nil if r carries no credentials of this
+	// method, or an error if it carries invalid ones.
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a copy of ctx carrying identity.
+func WithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// FromContext returns the identity the request context ctx carries, nil if it has none.
+func FromContext(ctx context.Context) *Identity {
+	identity, _ := ctx.Value(identityKey{}).(*Identity)
+	return identity
+}
+
+// Chain authenticates requests with the first of its authenticators accepting them.
+type Chain struct {
+	Authenticators []Authenticator
+	// LoginURL is where browsers are sent to log in, if set
+	LoginURL string
+	// BasicRealm asks clients for basic auth credentials, if set
+	BasicRealm string
+}
+
+// jsonResponseUnauthorized is the JsonResponseBasic of the controllers.
+type jsonResponseUnauthorized struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+// Filter is a beego filter rejecting requests none of the authenticators of c accepts.
+// The identity of accepted requests is attached to the context of the request.
+// It must run at beego.BeforeStatic to protect static files too.
+func (c *Chain) Filter(ctx *beecontext.Context) {
+	message := "Authentication required."
+	for _, authenticator := range c.Authenticators {
+		identity, err := authenticator.Authenticate(ctx.Request)
+		if err != nil {
+			message = "Authentication failed. " + err.Error()
+			continue
+		}
+		if identity != nil {
+			ctx.Request = ctx.Request.WithContext(WithIdentity(ctx.Request.Context(), identity))
+			return
+		}
+	}
+
+	if c.LoginURL != "" && ctx.Request.Method == http.MethodGet && !strings.HasPrefix(ctx.Request.URL.Path, "/v1/") {
+		ctx.Redirect(http.StatusFound, c.LoginURL+"?next="+url.QueryEscape(ctx.Request.URL.RequestURI()))
+		return
+	}
+	if c.BasicRealm != "" {
+		ctx.Output.Header("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", c.BasicRealm))
+	}
+	ctx.Output.SetStatus(http.StatusUnauthorized)
+	ctx.Output.JSON(jsonResponseUnauthorized{http.StatusUnauthorized, "Unauthorized", message}, false, false)
+}
+
+// TokenAuthenticator accepts static bearer tokens.
+type TokenAuthenticator struct {
+	tokens map[string]*Identity
+}
+
+// NewTokenAuthenticator reads the tokens from the CSV file at path, in the format of the
+// --token-auth-file of kube-apiserver: token,user,uid,"group1,group2". The uid is ignored.
+func NewTokenAuthenticator(path string) (*TokenAuthenticator, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.Comment = '#'
+	a := &TokenAuthenticator{tokens: map[string]*Identity{}}
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse tokens %s: %v", path, err)
+		}
+		if len(record) < 2 || record[0] == "" || record[1] == "" {
+			return nil, fmt.Errorf("tokens %s: each line needs a token and a user", path)
+		}
+		identity := &Identity{Name: record[1], Method: MethodToken}
+		if len(record) > 3 && record[3] != "" {
+			identity.Groups = strings.Split(record[3], ",")
+		}
+		a.tokens[record[0]] = identity
+	}
+	return a, nil
+}
+
+func (a *TokenAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	token := bearerToken(r)
+	if token == "" {
+		return nil, nil
+	}
+	for t, identity := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return identity, nil
+		}
+	}
+	// The token may be one of another authenticator
+	return nil, nil
+}
+
+// WebsocketTokenProtocolPrefix prefixes the websocket subprotocol carrying a base64url
+// encoded bearer token, as browsers cannot set the Authorization header of websockets.
+// It is the one kube-apiserver accepts.
+const WebsocketTokenProtocolPrefix = "base64url.bearer.authorization.k8s.io."
+
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	for _, protocols := range r.Header["Sec-Websocket-Protocol"] {
+		for _, protocol := range strings.Split(protocols, ",") {
+			protocol = strings.TrimSpace(protocol)
+			if strings.HasPrefix(protocol, WebsocketTokenProtocolPrefix) {
+				token, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(protocol, WebsocketTokenProtocolPrefix))
+				if err == nil {
+					return string(token)
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// BasicAuthenticator accepts the users of an htpasswd file with basic auth.
+type BasicAuthenticator struct {
+	hashes map[string]string
+}
+
+// NewBasicAuthenticator reads the users from the htpasswd file at path. Only bcrypt
+// (htpasswd -B) and SHA1 (htpasswd -s) passwords are supported.
+func NewBasicAuthenticator(path string) (*BasicAuthenticator, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	a := &BasicAuthenticator{hashes: map[string]string{}}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("htpasswd %s: lines must be user:password", path)
+		}
+		hash := parts[1]
+		if !strings.HasPrefix(hash, "$2") && !strings.HasPrefix(hash, "{SHA}") {
+			return nil, fmt.Errorf("htpasswd %s: the password of %s is neither bcrypt nor SHA1", path, parts[0])
+		}
+		a.hashes[parts[0]] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *BasicAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, nil
+	}
+	hash, ok := a.hashes[user]
+	if !ok || !checkPassword(hash, password) {
+		return nil, fmt.Errorf("invalid user or password")
+	}
+	return &Identity{Name: user, Method: MethodBasic}, nil
+}
+
+func checkPassword(hash, password string) bool {
+	if strings.HasPrefix(hash, "{SHA}") {
+		sum := sha1.Sum([]byte(password))
+		expected := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(expected)) == 1
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// Init sets up the authentication methods enabled in app.conf and protects the API,
+// the dashboard and the API docs with them. Without any method enabled they stay open.
+func Init() error {
+	chain := &Chain{}
+	if path := beego.AppConfig.String("authtokenfile"); path != "" {
+		a, err := NewTokenAuthenticator(path)
+		if err != nil {
+			return err
+		}
+		chain.Authenticators = append(chain.Authenticators, a)
+	}
+	if path := beego.AppConfig.String("authhtpasswdfile"); path != "" {
+		a, err := NewBasicAuthenticator(path)
+		if err != nil {
+			return err
+		}
+		chain.Authenticators = append(chain.Authenticators, a)
+		chain.BasicRealm = "virt-webui"
+	}
+	if issuer := beego.AppConfig.String("oidcissuer"); issuer != "" {
+		a, err := NewOIDCAuthenticator(context.Background(), OIDCConfig{
+			Issuer:        issuer,
+			ClientID:      beego.AppConfig.String("oidcclientid"),
+			ClientSecret:  beego.AppConfig.String("oidcclientsecret"),
+			RedirectURL:   beego.AppConfig.String("oidcredirecturl"),
+			UsernameClaim: beego.AppConfig.DefaultString("oidcusernameclaim", "sub"),
+			GroupsClaim:   beego.AppConfig.DefaultString("oidcgroupsclaim", "groups"),
+		})
+		if err != nil {
+			return err
+		}
+		chain.Authenticators = append(chain.Authenticators, a)
+		chain.LoginURL = LoginPath
+		beego.Get(LoginPath, a.Login)
+		beego.Get(CallbackPath, a.Callback)
+		beego.Get(LogoutPath, a.Logout)
+	}
+
+	if len(chain.Authenticators) == 0 {
+		beego.Warning("no authentication is configured, the API is open to anyone")
+		return nil
+	}
+	for _, pattern := range []string{"/v1/*", "/dashboard", "/dashboard/*", "/swagger/*"} {
+		beego.InsertFilter(pattern, beego.BeforeStatic, chain.Filter)
+	}
+	return nil
+}
@@ -0,0 +1,297 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	beecontext "github.com/astaxie/beego/context"
+	"golang.org/x/crypto/bcrypt"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+const testClientID = "virt-webui"
+
+// mockIssuer is an OpenID Connect provider issuing an ID token for the code "good".
+type mockIssuer struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	claims map[string]interface{}
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockIssuer{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                m.URL,
+			"authorization_endpoint":                m.URL + "/authorize",
+			"token_endpoint":                        m.URL + "/token",
+			"jwks_uri":                              m.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &key.PublicKey, KeyID: "test", Algorithm: "RS256", Use: "sig"},
+		}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.PostForm.Get("code") != "good" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"id_token":     m.sign(t, m.key, m.claims),
+		})
+	})
+	m.Server = httptest.NewServer(mux)
+	m.claims = map[string]interface{}{
+		"iss":    m.URL,
+		"aud":    testClientID,
+		"sub":    "alice",
+		"groups": []string{"admins"},
+		"iat":    time.Now().Unix(),
+		"exp":    time.Now().Add(time.Hour).Unix(),
+	}
+	return m
+}
+
+func (m *mockIssuer) sign(t *testing.T, key *rsa.PrivateKey, claims map[string]interface{}) string {
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: jose.JSONWebKey{Key: key, KeyID: "test"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, _ := json.Marshal(claims)
+	jws, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := jws.CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func newTestOIDCAuthenticator(t *testing.T, issuer *mockIssuer) *OIDCAuthenticator {
+	a, err := NewOIDCAuthenticator(context.Background(), OIDCConfig{
+		Issuer:      issuer.URL,
+		ClientID:    testClientID,
+		RedirectURL: "http://virt-webui" + CallbackPath,
+		GroupsClaim: "groups",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// serve runs handler on a request for target carrying cookies.
+func serve(handler func(*beecontext.Context), method, target string, header http.Header, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	ctx := beecontext.NewContext()
+	ctx.Reset(w, r)
+	handler(ctx)
+	return w
+}
+
+func TestOIDCBearerToken(t *testing.T) {
+	issuer := newMockIssuer(t)
+	defer issuer.Close()
+	a := newTestOIDCAuthenticator(t, issuer)
+
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	expired := map[string]interface{}{}
+	for k, v := range issuer.claims {
+		expired[k] = v
+	}
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+
+	for _, c := range []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"valid", issuer.sign(t, issuer.key, issuer.claims), true},
+		{"foreign key", issuer.sign(t, otherKey, issuer.claims), false},
+		{"expired", issuer.sign(t, issuer.key, expired), false},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/v1/vms/", nil)
+		r.Header.Set("Authorization", "Bearer "+c.token)
+		identity, err := a.Authenticate(r)
+		if !c.ok {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %v", c.name, identity)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if identity.Name != "alice" || fmt.Sprint(identity.Groups) != "[admins]" || identity.Method != MethodOIDC {
+			t.Errorf("%s: unexpected identity %+v", c.name, identity)
+		}
+	}
+}
+
+func TestOIDCLogin(t *testing.T) {
+	issuer := newMockIssuer(t)
+	defer issuer.Close()
+	a := newTestOIDCAuthenticator(t, issuer)
+
+	w := serve(a.Login, http.MethodGet, LoginPath+"?next="+url.QueryEscape("/dashboard/#/vms"), nil)
+	if w.Code != http.StatusFound {
+		t.Fatalf("login: got %d", w.Code)
+	}
+	location, _ := url.Parse(w.Header().Get("Location"))
+	if location.Path != "/authorize" || location.Query().Get("client_id") != testClientID {
+		t.Fatalf("login redirected to %s", location)
+	}
+	state := location.Query().Get("state")
+	stateCookies := w.Result().Cookies()
+
+	w = serve(a.Callback, http.MethodGet, CallbackPath+"?code=good&state=wrong", nil, stateCookies...)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("callback with a wrong state: got %d", w.Code)
+	}
+	w = serve(a.Callback, http.MethodGet, CallbackPath+"?code=bad&state="+state, nil, stateCookies...)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("callback with a bad code: got %d", w.Code)
+	}
+
+	w = serve(a.Callback, http.MethodGet, CallbackPath+"?code=good&state="+state, nil, stateCookies...)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/dashboard/#/vms" {
+		t.Fatalf("callback: got %d to %s", w.Code, w.Header().Get("Location"))
+	}
+	var session *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == sessionCookie {
+			session = c
+		}
+	}
+	if session == nil {
+		t.Fatal("callback set no session cookie")
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/vms/", nil)
+	r.AddCookie(session)
+	identity, err := a.Authenticate(r)
+	if err != nil || identity == nil || identity.Name != "alice" {
+		t.Errorf("session: got %+v, %v", identity, err)
+	}
+}
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestChainFilter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tokens, err := NewTokenAuthenticator(writeFile(t, dir, "tokens.csv", "# token,user,uid,groups\nsecret,bob,1,\"ops,dev\"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bcryptHash, _ := bcrypt.GenerateFromPassword([]byte("carol-pw"), bcrypt.MinCost)
+	shaSum := sha1.Sum([]byte("dave-pw"))
+	htpasswd := "carol:" + string(bcryptHash) + "\ndave:{SHA}" + base64.StdEncoding.EncodeToString(shaSum[:]) + "\n"
+	basic, err := NewBasicAuthenticator(writeFile(t, dir, "htpasswd", htpasswd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewBasicAuthenticator(writeFile(t, dir, "md5", "erin:$apr1$abc$def\n")); err == nil {
+		t.Error("expected an error for an MD5 htpasswd")
+	}
+
+	chain := &Chain{Authenticators: []Authenticator{tokens, basic}, BasicRealm: "virt-webui"}
+	var identity *Identity
+	handler := func(ctx *beecontext.Context) {
+		chain.Filter(ctx)
+		identity = FromContext(ctx.Request.Context())
+	}
+	basicAuth := func(user, password string) http.Header {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.SetBasicAuth(user, password)
+		return r.Header
+	}
+
+	for _, c := range []struct {
+		name   string
+		header http.Header
+		user   string
+	}{
+		{"no credentials", nil, ""},
+		{"token", http.Header{"Authorization": {"Bearer secret"}}, "bob"},
+		{"unknown token", http.Header{"Authorization": {"Bearer guess"}}, ""},
+		{"websocket token", http.Header{"Sec-Websocket-Protocol": {"binary, " + WebsocketTokenProtocolPrefix + "c2VjcmV0"}}, "bob"},
+		{"bcrypt", basicAuth("carol", "carol-pw"), "carol"},
+		{"sha1", basicAuth("dave", "dave-pw"), "dave"},
+		{"wrong password", basicAuth("carol", "dave-pw"), ""},
+	} {
+		identity = nil
+		w := serve(handler, http.MethodGet, "/v1/vms/", c.header)
+		if c.user == "" {
+			if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" || identity != nil {
+				t.Errorf("%s: got %d, identity %+v", c.name, w.Code, identity)
+			}
+			continue
+		}
+		if identity == nil || identity.Name != c.user {
+			t.Errorf("%s: got identity %+v, expected %s", c.name, identity, c.user)
+		}
+	}
+
+	serve(handler, http.MethodGet, "/v1/vms/", http.Header{"Authorization": {"Bearer secret"}})
+	if identity == nil || fmt.Sprint(identity.Groups) != "[ops dev]" {
+		t.Errorf("token groups: got %+v", identity)
+	}
+}
+
+func TestChainLoginRedirect(t *testing.T) {
+	chain := &Chain{LoginURL: LoginPath}
+	w := serve(chain.Filter, http.MethodGet, "/dashboard/", nil)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != LoginPath+"?next=%2Fdashboard%2F" {
+		t.Errorf("dashboard: got %d to %s", w.Code, w.Header().Get("Location"))
+	}
+	w = serve(chain.Filter, http.MethodGet, "/v1/vms/", nil)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("API: got %d", w.Code)
+	}
+}
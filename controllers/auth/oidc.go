@@ -0,0 +1,178 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	beecontext "github.com/astaxie/beego/context"
+	"github.com/coreos/go-oidc"
+	"golang.org/x/oauth2"
+)
+
+const (
+	LoginPath    = "/auth/login"
+	CallbackPath = "/auth/callback"
+	LogoutPath   = "/auth/logout"
+
+	// sessionCookie holds the ID token of a logged in browser
+	sessionCookie = "virt-webui-session"
+	// stateCookie holds the state and the page to return to of a login in progress
+	stateCookie = "virt-webui-login"
+)
+
+// OIDCConfig is the OpenID Connect provider users log in with.
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the CallbackPath of virt-webui as reached by browsers, e.g. https://virt-webui/auth/callback
+	RedirectURL string
+	// UsernameClaim and GroupsClaim are the ID token claims holding the user name and groups
+	UsernameClaim string
+	GroupsClaim   string
+}
+
+// OIDCAuthenticator accepts ID tokens of an OpenID Connect provider, either as bearer
+// tokens or in the session cookie set by its login flow.
+type OIDCAuthenticator struct {
+	config   OIDCConfig
+	verifier *oidc.IDTokenVerifier
+	oauth2   oauth2.Config
+}
+
+// NewOIDCAuthenticator discovers the provider of config.Issuer, so the issuer must be reachable.
+func NewOIDCAuthenticator(ctx context.Context, config OIDCConfig) (*OIDCAuthenticator, error) {
+	if config.ClientID == "" || config.RedirectURL == "" {
+		return nil, fmt.Errorf("OIDC needs a client ID and a redirect URL")
+	}
+	if config.UsernameClaim == "" {
+		config.UsernameClaim = "sub"
+	}
+	provider, err := oidc.NewProvider(ctx, config.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("cannot discover OIDC issuer %s: %v", config.Issuer, err)
+	}
+	return &OIDCAuthenticator{
+		config:   config,
+		verifier: provider.Verifier(&oidc.Config{ClientID: config.ClientID}),
+		oauth2: oauth2.Config{
+			ClientID:     config.ClientID,
+			ClientSecret: config.ClientSecret,
+			RedirectURL:  config.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       []string{oidc.ScopeOpenID, "profile", "email", "groups"},
+		},
+	}, nil
+}
+
+func (a *OIDCAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	rawToken := bearerToken(r)
+	if strings.Count(rawToken, ".") != 2 {
+		// Not a JWT, the token may be one of another authenticator
+		rawToken = ""
+	}
+	if rawToken == "" {
+		cookie, err := r.Cookie(sessionCookie)
+		if err != nil {
+			return nil, nil
+		}
+		rawToken = cookie.Value
+	}
+	return a.verify(r.Context(), rawToken)
+}
+
+func (a *OIDCAuthenticator) verify(ctx context.Context, rawToken string) (*Identity, error) {
+	token, err := a.verifier.Verify(ctx, rawToken)
+	if err != nil {
+		return nil, err
+	}
+	var claims map[string]interface{}
+	if err := token.Claims(&claims); err != nil {
+		return nil, err
+	}
+	name, _ := claims[a.config.UsernameClaim].(string)
+	if name == "" {
+		return nil, fmt.Errorf("the ID token has no %s claim", a.config.UsernameClaim)
+	}
+	identity := &Identity{Name: name, Method: MethodOIDC}
+	if groups, ok := claims[a.config.GroupsClaim].([]interface{}); ok {
+		for _, group := range groups {
+			if g, ok := group.(string); ok {
+				identity.Groups = append(identity.Groups, g)
+			}
+		}
+	}
+	return identity, nil
+}
+
+// Login sends the browser to the provider to log in.
+func (a *OIDCAuthenticator) Login(ctx *beecontext.Context) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		loginFailed(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	state := hex.EncodeToString(buf)
+	next := ctx.Input.Query("next")
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") {
+		next = "/dashboard/"
+	}
+	a.setCookie(ctx, stateCookie, state+"|"+next, 600)
+	ctx.Redirect(http.StatusFound, a.oauth2.AuthCodeURL(state))
+}
+
+// Callback completes a login started by Login and keeps the ID token in the session cookie.
+func (a *OIDCAuthenticator) Callback(ctx *beecontext.Context) {
+	parts := strings.SplitN(ctx.GetCookie(stateCookie), "|", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[0] != ctx.Input.Query("state") {
+		loginFailed(ctx, http.StatusBadRequest, "invalid state")
+		return
+	}
+	a.setCookie(ctx, stateCookie, "", -1)
+	if message := ctx.Input.Query("error"); message != "" {
+		loginFailed(ctx, http.StatusUnauthorized, message)
+		return
+	}
+
+	token, err := a.oauth2.Exchange(ctx.Request.Context(), ctx.Input.Query("code"))
+	if err != nil {
+		loginFailed(ctx, http.StatusUnauthorized, err.Error())
+		return
+	}
+	rawToken, _ := token.Extra("id_token").(string)
+	if _, err := a.verify(ctx.Request.Context(), rawToken); err != nil {
+		loginFailed(ctx, http.StatusUnauthorized, err.Error())
+		return
+	}
+	a.setCookie(ctx, sessionCookie, rawToken, 0)
+	ctx.Redirect(http.StatusFound, parts[1])
+}
+
+func loginFailed(ctx *beecontext.Context, status int, message string) {
+	ctx.Output.SetStatus(status)
+	ctx.Output.Body([]byte("Login failed. " + message))
+}
+
+// Logout forgets the session of the browser.
+func (a *OIDCAuthenticator) Logout(ctx *beecontext.Context) {
+	a.setCookie(ctx, sessionCookie, "", -1)
+	ctx.Redirect(http.StatusFound, "/dashboard/")
+}
+
+// setCookie sets an HTTP only cookie, secure when virt-webui is reached over https.
+// A maxAge of 0 makes a session cookie, a negative one deletes the cookie.
+func (a *OIDCAuthenticator) setCookie(ctx *beecontext.Context, name, value string, maxAge int) {
+	http.SetCookie(ctx.ResponseWriter, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(a.config.RedirectURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
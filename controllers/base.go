@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"virt-webui/controllers/audit"
+	"virt-webui/controllers/auth"
+	"virt-webui/models"
+
+	"github.com/astaxie/beego"
+	"kubevirt.io/client-go/kubecli"
+)
+
+// baseController holds what the VM and image controllers share.
+type baseController struct {
+	beego.Controller
+
+	// namespace is the namespace GetVirtClient resolved for the request
+	namespace string
+}
+
+// ResponseNotAvaliable responds that the cluster cannot be reached, err tells why.
+func (b *baseController) ResponseNotAvaliable(err error) {
+	if _, ok := err.(*APIError); ok {
+		b.SetError("", err)
+	} else {
+		b.SetError("Not avaliable.", &APIError{Status: http.StatusServiceUnavailable, Code: ErrCodeUnavailable, Message: err.Error()})
+	}
+	b.ServeJSON()
+}
+
+// SetError makes err, prefixed by message if set, the response of the request. The HTTP
+// status and the Code of the response are those toAPIError maps err to.
+func (b *baseController) SetError(message string, err error) {
+	apiErr := toAPIError(err)
+	if apiErr.Code == ErrCodeUnauthorized {
+		// The credentials of the shared client may have been rotated
+		if cluster, err := clusters.get(b.Ctx.Input.Param(":cluster")); err == nil {
+			cluster.invalidate()
+		}
+	}
+	if message != "" {
+		message += " "
+	}
+	b.Ctx.Output.SetStatus(apiErr.Status)
+	b.Data["json"] = JsonResponseBasic{StatusCode: apiErr.Status, Code: apiErr.Code, Message: message + apiErr.Message}
+}
+
+// Identity returns the authenticated user of the request, nil if authentication is disabled.
+func (b *baseController) Identity() *auth.Identity {
+	return auth.FromContext(b.Ctx.Request.Context())
+}
+
+// userName names the user of the request in logs, by the remote address without authentication.
+func (b *baseController) userName() string {
+	if identity := b.Identity(); identity != nil {
+		return identity.Name
+	}
+	return b.Ctx.Input.IP()
+}
+
+// GetVirtClient is GetVirtClient for the cluster and namespace of the request. Routes under
+// /v1/clusters/:cluster act on :cluster, the others on the default cluster. Routes under
+// /v1/namespaces/:ns act on :ns, the others on the default namespace of the kubeconfig.
+// Unless impersonate is disabled in app.conf, the client impersonates the authenticated
+// user, so the RBAC rules of the cluster decide what the user may do.
+func (b *baseController) GetVirtClient() (*string, *kubecli.KubevirtClient, error) {
+	var identity *auth.Identity
+	if beego.AppConfig.DefaultBool("impersonate", true) {
+		identity = b.Identity()
+	}
+	namespace, virtClient, err := getClusterClient(b.Ctx.Input.Param(":cluster"), identity)
+	if ns := b.Ctx.Input.Param(":ns"); err == nil && ns != "" {
+		namespace = &ns
+	}
+	if err == nil {
+		b.namespace = *namespace
+	}
+	return namespace, virtClient, err
+}
+
+// Finish records the mutating calls in the audit log.
+func (b *baseController) Finish() {
+	switch b.Ctx.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return
+	}
+	entry := b.auditEntry()
+	entry.StatusCode = b.Ctx.ResponseWriter.Status
+	if body := b.Ctx.Input.RequestBody; len(body) > 0 {
+		sum := sha256.Sum256(body)
+		entry.BodyDigest = hex.EncodeToString(sum[:])
+	}
+	if entry.StatusCode == 0 {
+		entry.StatusCode = http.StatusOK
+	}
+	if entry.StatusCode >= http.StatusBadRequest {
+		entry.Result = audit.ResultFailure
+		if response, ok := b.Data["json"].(JsonResponseBasic); ok {
+			entry.Error = response.Message
+		}
+	}
+	audit.Record(entry)
+}
+
+// auditEntry returns the audit entry of the request, without its outcome.
+func (b *baseController) auditEntry() *models.AuditEntry {
+	controller, action := b.GetControllerAndAction()
+	entry := &models.AuditEntry{
+		RemoteAddr: b.Ctx.Input.IP(),
+		Cluster:    b.Ctx.Input.Param(":cluster"),
+		Namespace:  b.namespace,
+		Resource:   strings.TrimSuffix(controller, "Controller"),
+		Action:     action,
+		Target:     b.auditTarget(),
+		Result:     audit.ResultSuccess,
+	}
+	if identity := b.Identity(); identity != nil {
+		entry.User = identity.Name
+	}
+	if entry.Cluster == "" {
+		entry.Cluster = clusters.defaultName()
+	}
+	return entry
+}
+
+// auditTarget returns the name of the VM or image the request acts on, from the route,
+// the Name of a JSON request body or the Name query parameter.
+func (b *baseController) auditTarget() string {
+	for _, param := range []string{":VMName", ":ImageName", ":JobID", ":MigrationName"} {
+		if target := b.Ctx.Input.Param(param); target != "" {
+			return target
+		}
+	}
+	var body struct{ Name string }
+	if json.Unmarshal(b.Ctx.Input.RequestBody, &body) == nil && body.Name != "" {
+		return body.Name
+	}
+	return b.Ctx.Input.Query("Name")
+}
@@ -0,0 +1,267 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"virt-webui/controllers/auth"
+
+	"github.com/astaxie/beego"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"kubevirt.io/client-go/kubecli"
+)
+
+const (
+	// kubeconfigCheckInterval is how often the files the client was built from are checked for changes
+	kubeconfigCheckInterval = 10 * time.Second
+
+	serviceAccountCAFile = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+
+	// impersonatedCacheSize bounds the clients of impersonated users kept per cluster
+	impersonatedCacheSize = 256
+)
+
+// clientProvider builds the KubeVirt client of a cluster once and shares it between requests.
+// The client is rebuilt when the kubeconfig or service account CA it was built from
+// changes, or when the API server rejects its credentials, so rotated credentials are
+// picked up without a restart. Service account tokens are re-read by client-go itself.
+type clientProvider struct {
+	sync.Mutex
+	kubeconfig  string
+	kubeContext string
+
+	config    *rest.Config
+	context   string
+	namespace string
+	client    kubecli.KubevirtClient
+	checksum  [sha256.Size]byte
+	checked   time.Time
+
+	// impersonated are the clients of the users impersonated with config, by user and groups
+	impersonated map[string]kubecli.KubevirtClient
+}
+
+// DefaultCluster is the name of the cluster set up by kubeconfig and kubecontext in app.conf.
+const DefaultCluster = "default"
+
+// clusterConfig is an entry of the clusters file.
+type clusterConfig struct {
+	Name string
+	// Kubeconfig is the path of the kubeconfig of the cluster, $KUBECONFIG or ~/.kube/config if empty
+	Kubeconfig string
+	// Context of the kubeconfig to use, its current context if empty
+	Context string
+}
+
+// clusterRegistry holds a client provider per managed cluster.
+type clusterRegistry struct {
+	sync.RWMutex
+	names     []string
+	providers map[string]*clientProvider
+}
+
+var clusters = &clusterRegistry{providers: map[string]*clientProvider{}}
+
+// LoadClusters sets up the clusters listed in the JSON file clustersFile, the first one
+// being the default cluster. Without a clusters file the only cluster is DefaultCluster,
+// from the kubeconfig at path using its context kubeContext. An empty path uses $KUBECONFIG
+// or ~/.kube/config, and the service account of the pod when neither exists. An empty
+// kubeContext uses the current context. A cluster that cannot be reached yet is retried on
+// the next request for it.
+func LoadClusters(path, kubeContext, clustersFile string) error {
+	configs := []clusterConfig{{Name: DefaultCluster, Kubeconfig: path, Context: kubeContext}}
+	if clustersFile != "" {
+		data, err := ioutil.ReadFile(clustersFile)
+		if err != nil {
+			return err
+		}
+		configs = nil
+		if err := json.Unmarshal(data, &configs); err != nil {
+			return fmt.Errorf("cannot parse clusters %s: %v", clustersFile, err)
+		}
+		if len(configs) == 0 {
+			return fmt.Errorf("no clusters in %s", clustersFile)
+		}
+	}
+
+	providers := map[string]*clientProvider{}
+	var names []string
+	for _, config := range configs {
+		if config.Name == "" || providers[config.Name] != nil {
+			return fmt.Errorf("cluster names must be set and unique")
+		}
+		p := &clientProvider{kubeconfig: config.Kubeconfig, kubeContext: config.Context}
+		if err := p.connect(); err != nil {
+			beego.Warning("cannot build the client of cluster", config.Name+", retrying on the first request:", err)
+		}
+		providers[config.Name] = p
+		names = append(names, config.Name)
+	}
+
+	clusters.Lock()
+	defer clusters.Unlock()
+	clusters.names = names
+	clusters.providers = providers
+	return nil
+}
+
+// get returns the provider of the cluster name, of the default cluster if name is empty.
+func (r *clusterRegistry) get(name string) (*clientProvider, error) {
+	r.RLock()
+	defer r.RUnlock()
+	if len(r.names) == 0 {
+		return nil, fmt.Errorf("no clusters are configured")
+	}
+	if name == "" {
+		name = r.names[0]
+	}
+	p, ok := r.providers[name]
+	if !ok {
+		return nil, newAPIError(http.StatusNotFound, ErrCodeNotFound, "Cluster %s not found.", name)
+	}
+	return p, nil
+}
+
+// defaultName returns the name of the default cluster.
+func (r *clusterRegistry) defaultName() string {
+	r.RLock()
+	defer r.RUnlock()
+	if len(r.names) == 0 {
+		return ""
+	}
+	return r.names[0]
+}
+
+// list returns the names of the clusters, the default cluster first.
+func (r *clusterRegistry) list() []string {
+	r.RLock()
+	defer r.RUnlock()
+	return append([]string(nil), r.names...)
+}
+
+func (p *clientProvider) clientConfig() (clientcmd.ClientConfig, *clientcmd.ClientConfigLoadingRules) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = p.kubeconfig
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: p.kubeContext}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides), rules
+}
+
+// sourceChecksum sums up the files the client config is loaded from. Missing files are skipped.
+func (p *clientProvider) sourceChecksum(rules *clientcmd.ClientConfigLoadingRules) [sha256.Size]byte {
+	files := rules.GetLoadingPrecedence()
+	if rules.ExplicitPath != "" {
+		files = []string{rules.ExplicitPath}
+	}
+	h := sha256.New()
+	for _, file := range append(files, serviceAccountCAFile) {
+		if data, err := ioutil.ReadFile(file); err == nil {
+			h.Write([]byte(file))
+			h.Write(data)
+		}
+	}
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// connect builds the client from the current client config.
+func (p *clientProvider) connect() error {
+	clientConfig, rules := p.clientConfig()
+	checksum := p.sourceChecksum(rules)
+
+	config, err := clientConfig.ClientConfig()
+	if err != nil {
+		return err
+	}
+	namespace, _, err := clientConfig.Namespace()
+	if err != nil {
+		return err
+	}
+	context := p.kubeContext
+	if raw, err := clientConfig.RawConfig(); err == nil && context == "" {
+		context = raw.CurrentContext
+	}
+	client, err := kubecli.GetKubevirtClientFromRESTConfig(rest.CopyConfig(config))
+	if err != nil {
+		return err
+	}
+
+	p.config = config
+	p.context = context
+	p.namespace = namespace
+	p.client = client
+	p.impersonated = nil
+	p.checksum = checksum
+	p.checked = time.Now()
+	return nil
+}
+
+// get returns the shared client and the default namespace of its config.
+func (p *clientProvider) get() (string, kubecli.KubevirtClient, *rest.Config, error) {
+	p.Lock()
+	defer p.Unlock()
+	if p.client != nil && time.Since(p.checked) > kubeconfigCheckInterval {
+		_, rules := p.clientConfig()
+		if p.sourceChecksum(rules) != p.checksum {
+			p.client = nil
+		}
+		p.checked = time.Now()
+	}
+	if p.client == nil {
+		if err := p.connect(); err != nil {
+			return "", nil, nil, err
+		}
+	}
+	return p.namespace, p.client, p.config, nil
+}
+
+// invalidate makes the next request rebuild the client, e.g. after its credentials were rejected.
+func (p *clientProvider) invalidate() {
+	p.Lock()
+	defer p.Unlock()
+	p.client = nil
+	p.impersonated = nil
+}
+
+// getAs is get for a client impersonating identity, so the cluster authorizes its
+// requests by the RBAC rules of identity instead of those of the server.
+func (p *clientProvider) getAs(identity *auth.Identity) (string, kubecli.KubevirtClient, error) {
+	namespace, _, config, err := p.get()
+	if err != nil {
+		return "", nil, err
+	}
+	key := identity.Name + "\x00" + strings.Join(identity.Groups, "\x00")
+
+	p.Lock()
+	defer p.Unlock()
+	if client, ok := p.impersonated[key]; ok && p.config == config {
+		return namespace, client, nil
+	}
+	impersonating := rest.CopyConfig(config)
+	impersonating.Impersonate = rest.ImpersonationConfig{UserName: identity.Name, Groups: identity.Groups}
+	client, err := kubecli.GetKubevirtClientFromRESTConfig(impersonating)
+	if err != nil {
+		return "", nil, err
+	}
+	// Clients built from a config replaced meanwhile are not kept
+	if p.config == config {
+		if p.impersonated == nil || len(p.impersonated) >= impersonatedCacheSize {
+			p.impersonated = map[string]kubecli.KubevirtClient{}
+		}
+		p.impersonated[key] = client
+	}
+	return namespace, client, nil
+}
+
+// currentContext returns the kubeconfig context the client was last built from.
+func (p *clientProvider) currentContext() string {
+	p.Lock()
+	defer p.Unlock()
+	return p.context
+}
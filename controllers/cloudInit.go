@@ -0,0 +1,193 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+	k8sv1 "k8s.io/api/core/v1"
+	k8smetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1 "kubevirt.io/client-go/api/v1"
+	"kubevirt.io/client-go/kubecli"
+)
+
+const (
+	CloudInitNoCloud     = "NoCloud"
+	CloudInitConfigDrive = "ConfigDrive"
+
+	cloudInitDiskName = "cloudinitdisk"
+
+	// maxInlineCloudInitSize is the size above which the cloud-init data is kept in a Secret
+	// instead of the VM spec. KubeVirt rejects inline user data larger than 2048 bytes.
+	maxInlineCloudInitSize = 2048
+
+	// Keys KubeVirt reads the cloud-init data of a Secret from
+	cloudInitUserDataKey    = "userdata"
+	cloudInitNetworkDataKey = "networkdata"
+
+	cloudConfigHeader = "#cloud-config"
+)
+
+type JsonCloudInit struct {
+	// Type is "NoCloud" (the default) or "ConfigDrive"
+	Type        string
+	UserData    string
+	NetworkData string
+	// SSHKeys are public keys added to the default user. They are merged into the ssh_authorized_keys
+	// of UserData, which must be a #cloud-config document if it is set.
+	SSHKeys []string
+}
+
+// cloudInitUserData returns the user data of cloudInit with its SSH keys merged in.
+func cloudInitUserData(cloudInit *JsonCloudInit) (string, error) {
+	userData := cloudInit.UserData
+	if len(cloudInit.SSHKeys) == 0 {
+		return userData, nil
+	}
+	if userData != "" && !strings.HasPrefix(userData, cloudConfigHeader) {
+		return "", fmt.Errorf("SSHKeys can only be merged into #cloud-config user data")
+	}
+
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal([]byte(userData), &doc); err != nil {
+		return "", fmt.Errorf("UserData is not valid YAML: %v", err)
+	}
+	var keys []interface{}
+	index := -1
+	for n, item := range doc {
+		switch item.Key {
+		case "ssh_authorized_keys":
+			var ok bool
+			if keys, ok = item.Value.([]interface{}); !ok && item.Value != nil {
+				return "", fmt.Errorf("ssh_authorized_keys of UserData is not a list")
+			}
+			index = n
+		case "users":
+			if !hasDefaultUser(item.Value) {
+				return "", fmt.Errorf("SSHKeys are added to the default user, which the users of UserData leave out")
+			}
+		}
+	}
+	for _, key := range cloudInit.SSHKeys {
+		key = strings.TrimSpace(key)
+		if key == "" || strings.ContainsAny(key, "\r\n") {
+			return "", fmt.Errorf("invalid SSH key %q", key)
+		}
+		keys = append(keys, key)
+	}
+	if index < 0 {
+		doc = append(doc, yaml.MapItem{Key: "ssh_authorized_keys", Value: keys})
+	} else {
+		doc[index].Value = keys
+	}
+
+	out, err := yaml.Marshal(doc)
+	if err != nil {
+		return "", err
+	}
+	return cloudConfigHeader + "\n" + string(out), nil
+}
+
+// hasDefaultUser tells whether the users of a #cloud-config document include the default user of the image.
+func hasDefaultUser(users interface{}) bool {
+	switch users := users.(type) {
+	case nil:
+		return true
+	case string:
+		for _, user := range strings.Split(users, ",") {
+			if strings.TrimSpace(user) == "default" {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, user := range users {
+			if user == "default" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// validateCloudInit checks cloudInit before anything is created for it.
+func validateCloudInit(cloudInit *JsonCloudInit) error {
+	switch cloudInit.Type {
+	case "", CloudInitNoCloud, CloudInitConfigDrive:
+	default:
+		return fmt.Errorf("unknown cloud-init Type %s", cloudInit.Type)
+	}
+	userData, err := cloudInitUserData(cloudInit)
+	if err != nil {
+		return err
+	}
+	if userData == "" {
+		return fmt.Errorf("cloud-init needs UserData or SSHKeys")
+	}
+	return nil
+}
+
+func cloudInitSecretName(vmName string) string {
+	return vmName + "-cloudinit"
+}
+
+// addCloudInit adds the cloud-init disk described by the validated cloudInit to vm. Data too large
+// to be inlined is stored in a new Secret; the returned Secret name is empty if none was created.
+func addCloudInit(client kubecli.KubevirtClient, namespace string, vm *v1.VirtualMachine, cloudInit *JsonCloudInit) (string, error) {
+	userData, err := cloudInitUserData(cloudInit)
+	if err != nil {
+		return "", err
+	}
+
+	var userDataRef, networkDataRef *k8sv1.LocalObjectReference
+	networkData := cloudInit.NetworkData
+	secretName := ""
+	if len(userData)+len(networkData) > maxInlineCloudInitSize {
+		secretName = cloudInitSecretName(vm.Name)
+		secret := &k8sv1.Secret{
+			ObjectMeta: k8smetav1.ObjectMeta{
+				Name: secretName,
+			},
+			StringData: map[string]string{
+				cloudInitUserDataKey: userData,
+			},
+		}
+		userDataRef = &k8sv1.LocalObjectReference{Name: secretName}
+		if networkData != "" {
+			secret.StringData[cloudInitNetworkDataKey] = networkData
+			networkDataRef = userDataRef
+		}
+		if _, err := client.CoreV1().Secrets(namespace).Create(secret); err != nil {
+			return "", err
+		}
+		userData, networkData = "", ""
+	}
+
+	volume := v1.Volume{Name: cloudInitDiskName}
+	if cloudInit.Type == CloudInitConfigDrive {
+		volume.CloudInitConfigDrive = &v1.CloudInitConfigDriveSource{
+			UserData:             userData,
+			UserDataSecretRef:    userDataRef,
+			NetworkData:          networkData,
+			NetworkDataSecretRef: networkDataRef,
+		}
+	} else {
+		volume.CloudInitNoCloud = &v1.CloudInitNoCloudSource{
+			UserData:             userData,
+			UserDataSecretRef:    userDataRef,
+			NetworkData:          networkData,
+			NetworkDataSecretRef: networkDataRef,
+		}
+	}
+
+	spec := &vm.Spec.Template.Spec
+	spec.Volumes = append(spec.Volumes, volume)
+	spec.Domain.Devices.Disks = append(spec.Domain.Devices.Disks, v1.Disk{
+		Name: cloudInitDiskName,
+		DiskDevice: v1.DiskDevice{
+			Disk: &v1.DiskTarget{
+				Bus: "virtio",
+			},
+		},
+	})
+	return secretName, nil
+}
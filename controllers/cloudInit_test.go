@@ -0,0 +1,77 @@
+package controllers
+
+import "testing"
+
+func TestCloudInitUserData(t *testing.T) {
+	for _, test := range []struct {
+		name, userData string
+		sshKeys        []string
+		expected       string
+		expectedErr    bool
+	}{{
+		name:     "no keys",
+		userData: "#!/bin/sh\necho hello\n",
+		expected: "#!/bin/sh\necho hello\n",
+	}, {
+		name:     "keys only",
+		sshKeys:  []string{"ssh-ed25519 AAAA alice"},
+		expected: "#cloud-config\nssh_authorized_keys:\n- ssh-ed25519 AAAA alice\n",
+	}, {
+		name:     "header without newline",
+		userData: "#cloud-config",
+		sshKeys:  []string{"ssh-ed25519 AAAA alice"},
+		expected: "#cloud-config\nssh_authorized_keys:\n- ssh-ed25519 AAAA alice\n",
+	}, {
+		name:     "merged into the keys of UserData",
+		userData: "#cloud-config\nssh_authorized_keys:\n  - ssh-rsa BBBB bob\npackages: [git]\n",
+		sshKeys:  []string{"ssh-ed25519 AAAA alice"},
+		expected: "#cloud-config\nssh_authorized_keys:\n- ssh-rsa BBBB bob\n- ssh-ed25519 AAAA alice\npackages:\n- git\n",
+	}, {
+		name:     "document ending in a block scalar",
+		userData: "#cloud-config\nruncmd:\n  - |\n    echo one\n    echo two\n",
+		sshKeys:  []string{"ssh-ed25519 AAAA alice"},
+		expected: "#cloud-config\nruncmd:\n- |\n  echo one\n  echo two\nssh_authorized_keys:\n- ssh-ed25519 AAAA alice\n",
+	}, {
+		name:     "users with the default user",
+		userData: "#cloud-config\nusers:\n  - default\n  - name: bob\n    ssh_authorized_keys: [ssh-rsa BBBB bob]\n",
+		sshKeys:  []string{"ssh-ed25519 AAAA alice"},
+		expected: "#cloud-config\nusers:\n- default\n- name: bob\n  ssh_authorized_keys:\n  - ssh-rsa BBBB bob\nssh_authorized_keys:\n- ssh-ed25519 AAAA alice\n",
+	}, {
+		name:        "users without the default user",
+		userData:    "#cloud-config\nusers:\n  - name: bob\n    ssh_authorized_keys: [ssh-rsa BBBB bob]\n",
+		sshKeys:     []string{"ssh-ed25519 AAAA alice"},
+		expectedErr: true,
+	}, {
+		name:        "keys that are not a list",
+		userData:    "#cloud-config\nssh_authorized_keys: ssh-rsa BBBB bob\n",
+		sshKeys:     []string{"ssh-ed25519 AAAA alice"},
+		expectedErr: true,
+	}, {
+		name:        "shell script",
+		userData:    "#!/bin/sh\necho hello\n",
+		sshKeys:     []string{"ssh-ed25519 AAAA alice"},
+		expectedErr: true,
+	}, {
+		name:        "invalid YAML",
+		userData:    "#cloud-config\npackages: [git\n",
+		sshKeys:     []string{"ssh-ed25519 AAAA alice"},
+		expectedErr: true,
+	}, {
+		name:        "key with a newline",
+		sshKeys:     []string{"ssh-ed25519 AAAA\nalice"},
+		expectedErr: true,
+	}} {
+		got, err := cloudInitUserData(&JsonCloudInit{UserData: test.userData, SSHKeys: test.sshKeys})
+		if test.expectedErr {
+			if err == nil {
+				t.Errorf("%s: got %q, expected an error", test.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if got != test.expected {
+			t.Errorf("%s: got %q, expected %q", test.name, got, test.expected)
+		}
+	}
+}
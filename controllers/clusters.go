@@ -0,0 +1,40 @@
+package controllers
+
+import (
+	"virt-webui/models"
+)
+
+// Operations about cluster
+type ClusterController struct {
+	baseController
+}
+
+// @Title List Cluster
+// @Description List the managed clusters. VMs, images and namespaces of a cluster are under /v1/clusters/:cluster/vms, /v1/clusters/:cluster/images and /v1/clusters/:cluster/namespaces.
+// @Success 200 {object} controllers.JsonResponseListClusterSuccess
+// @router / [get]
+func (c *ClusterController) GetAll() {
+	var list []models.Cluster
+	for n, name := range clusters.list() {
+		cluster := models.Cluster{Name: name, Default: n == 0}
+		if provider, err := clusters.get(name); err != nil {
+			cluster.Message = err.Error()
+		} else if namespace, _, config, err := provider.get(); err != nil {
+			cluster.Message = err.Error()
+		} else {
+			cluster.Context = provider.currentContext()
+			cluster.Server = config.Host
+			cluster.Namespace = namespace
+			cluster.Available = true
+		}
+		list = append(list, cluster)
+	}
+	c.Data["json"] = JsonResponseListClusterSuccess{200, "Clusters list success.", list}
+	c.ServeJSON()
+}
+
+type JsonResponseListClusterSuccess struct {
+	StatusCode int
+	Message    string
+	Clusters   []models.Cluster
+}
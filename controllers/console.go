@@ -0,0 +1,474 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+	"virt-webui/controllers/audit"
+	"virt-webui/models"
+
+	"github.com/astaxie/beego"
+	"github.com/gorilla/websocket"
+	authv1 "k8s.io/api/authorization/v1"
+	"kubevirt.io/client-go/kubecli"
+)
+
+const (
+	// Modes of a serial console client: one client at a time writes, the others only read
+	ConsoleModeWrite = "write"
+	ConsoleModeRead  = "read"
+
+	// consoleConnectTimeout is how long a console waits for the instance of a starting VM
+	consoleConnectTimeout = 10 * time.Second
+	// consoleSendQueue is the number of messages a client may lag behind before being dropped
+	consoleSendQueue = 256
+	// consoleTranscriptLimit caps the transcript of a user recorded in the audit log
+	consoleTranscriptLimit = 1 << 20
+)
+
+// JsonConsoleMode is sent to a console client as a text message whenever its mode changes.
+// The binary messages carry the console data.
+type JsonConsoleMode struct {
+	Mode string
+}
+
+// @Title Serial Console
+// @Description Open a websocket to the serial console of a running virtual machine. The console is shared: the first client asking to write gets to type, the others only see the output until the writer leaves. A text message tells each client its mode, binary messages carry the console data. Browsers authenticate like for the VNC console. The writers are recorded in the audit log, with what the console printed when consoletranscript is enabled in app.conf.
+// @Param	VMName	path	string	true	"The VM you want to access"
+// @Param	mode	query	string	false	"read to only watch the console, write by default"
+// @Success 101 Switching protocols to the websocket.
+// @Failure 403 Forbidden by the RBAC rules of the cluster.
+// @Failure 404 VM not running.
+// @Failure 500 Failed to connect to the serial console.
+// @router /:VMName/console [get]
+func (v *VMController) Console() {
+	v.EnableRender = false
+	namespace, virtClient, err := v.GetVirtClient()
+	if err != nil {
+		v.ResponseNotAvaliable(err)
+		return
+	}
+
+	vmName := v.Ctx.Input.Param(":VMName")
+	cluster := v.Ctx.Input.Param(":cluster")
+	if cluster == "" {
+		cluster = clusters.defaultName()
+	}
+	session, created, err := consoleSessions.join(cluster+"/"+*namespace+"/"+vmName, func() (kubecli.StreamInterface, error) {
+		return (*virtClient).VirtualMachineInstance(*namespace).SerialConsole(vmName,
+			&kubecli.SerialConsoleOptions{ConnectionTimeout: consoleConnectTimeout})
+	})
+	if err == nil && !created {
+		// The session was opened with the credentials of another user
+		if err = authorizeConsole(*virtClient, *namespace, vmName); err != nil {
+			consoleSessions.leave(session)
+		}
+	}
+	if err != nil {
+		v.SetError("Failed to connect to the serial console of "+vmName+".", err)
+		v.ServeJSON()
+		return
+	}
+	defer consoleSessions.leave(session)
+
+	conn, err := wsUpgrader.Upgrade(v.Ctx.ResponseWriter, v.Ctx.Request, nil)
+	if err != nil {
+		// The upgrader replied already
+		return
+	}
+	entry := v.auditEntry()
+	entry.StatusCode = http.StatusSwitchingProtocols
+	beego.Info("serial console of", *namespace+"/"+vmName, "opened for", v.userName())
+	session.serve(conn, v.GetString("mode") != ConsoleModeRead, entry)
+	beego.Info("serial console of", *namespace+"/"+vmName, "closed for", v.userName())
+}
+
+// authorizeConsole checks that the user of virtClient may open the serial console of vmName.
+func authorizeConsole(virtClient kubecli.KubevirtClient, namespace, vmName string) error {
+	review, err := virtClient.AuthorizationV1().SelfSubjectAccessReviews().Create(&authv1.SelfSubjectAccessReview{
+		Spec: authv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authv1.ResourceAttributes{
+				Namespace:   namespace,
+				Verb:        "get",
+				Group:       "subresources.kubevirt.io",
+				Resource:    "virtualmachineinstances",
+				Subresource: "console",
+				Name:        vmName,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if !review.Status.Allowed {
+		return newAPIError(http.StatusForbidden, ErrCodeForbidden, "The console of %s is forbidden by the RBAC rules of the cluster.", vmName)
+	}
+	return nil
+}
+
+// consoleSessions shares the serial console of each VM between its clients, KubeVirt
+// accepting a single connection to a console.
+var consoleSessions = &consoleRegistry{sessions: map[string]*consoleSession{}}
+
+type consoleRegistry struct {
+	sync.Mutex
+	sessions map[string]*consoleSession
+}
+
+// join returns the session of the console key, opening it with connect if there is none,
+// and tells whether it did. The session stays open until the caller leaves it.
+func (r *consoleRegistry) join(key string, connect func() (kubecli.StreamInterface, error)) (*consoleSession, bool, error) {
+	r.Lock()
+	session, ok := r.sessions[key]
+	if !ok {
+		session = &consoleSession{key: key, ready: make(chan struct{}), done: make(chan struct{})}
+		r.sessions[key] = session
+	}
+	session.refs++
+	r.Unlock()
+
+	if !ok {
+		session.open(connect())
+	}
+	<-session.ready
+	if session.err != nil {
+		r.leave(session)
+		return nil, false, session.err
+	}
+	return session, !ok, nil
+}
+
+// leave closes session once all the callers of join left it.
+func (r *consoleRegistry) leave(session *consoleSession) {
+	r.Lock()
+	session.refs--
+	last := session.refs == 0
+	if last {
+		r.removeLocked(session)
+	}
+	r.Unlock()
+	if last {
+		session.close(websocket.CloseNormalClosure, "")
+	}
+}
+
+// remove forgets session, so the next client opens a new one.
+func (r *consoleRegistry) remove(session *consoleSession) {
+	r.Lock()
+	defer r.Unlock()
+	r.removeLocked(session)
+}
+
+func (r *consoleRegistry) removeLocked(session *consoleSession) {
+	if r.sessions[session.key] == session {
+		delete(r.sessions, session.key)
+	}
+}
+
+// consoleSession is the connection to the serial console of a VM shared by its clients.
+type consoleSession struct {
+	// lastActive is the UnixNano time the console was last used, first for 64-bit alignment
+	lastActive int64
+	key        string
+	// ready is closed once the console is connected, or failed to with err
+	ready chan struct{}
+	err   error
+	// refs counts the callers of join, guarded by the registry
+	refs int
+
+	stream    *io.PipeWriter
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu      sync.Mutex
+	clients []*consoleClient
+	writer  *consoleClient
+	closed  bool
+}
+
+// open starts copying the console stream to the clients, unless err tells it failed.
+func (s *consoleSession) open(stream kubecli.StreamInterface, err error) {
+	if err != nil {
+		s.err = err
+		close(s.ready)
+		return
+	}
+	reader, writer := io.Pipe()
+	s.stream = writer
+	s.active()
+	close(s.ready)
+	go func() {
+		err := stream.Stream(kubecli.StreamOptions{In: reader, Out: s})
+		if err != nil {
+			beego.Info("serial console", s.key, "ended:", err)
+		}
+		consoleSessions.remove(s)
+		s.close(websocket.CloseGoingAway, "The console was closed.")
+	}()
+	go s.closeIdle(consoleIdleTimeout())
+}
+
+func (s *consoleSession) active() {
+	atomic.StoreInt64(&s.lastActive, time.Now().UnixNano())
+}
+
+// closeIdle closes the session once the console is unused for timeout, unless it is 0.
+func (s *consoleSession) closeIdle(timeout time.Duration) {
+	if timeout <= 0 {
+		return
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-timer.C:
+			idle := time.Since(time.Unix(0, atomic.LoadInt64(&s.lastActive)))
+			if idle < timeout {
+				timer.Reset(timeout - idle)
+				continue
+			}
+			consoleSessions.remove(s)
+			s.close(websocket.ClosePolicyViolation, "Idle timeout.")
+			return
+		}
+	}
+}
+
+// close disconnects the console and all its clients, telling them why.
+func (s *consoleSession) close(code int, reason string) {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		if s.stream != nil {
+			s.stream.Close()
+		}
+		s.mu.Lock()
+		s.closed = true
+		var entry *models.AuditEntry
+		if s.writer != nil {
+			entry = s.writer.stopWriting()
+			s.writer = nil
+		}
+		for _, client := range s.clients {
+			client.closeWith(code, reason)
+		}
+		s.clients = nil
+		s.mu.Unlock()
+		if entry != nil {
+			audit.Record(entry)
+		}
+	})
+}
+
+// serve connects conn to the console until either closes. entry is recorded in the audit
+// log for each time the client writes.
+func (s *consoleSession) serve(conn *websocket.Conn, wantsWrite bool, entry *models.AuditEntry) {
+	client := &consoleClient{
+		conn:       conn,
+		wantsWrite: wantsWrite,
+		entry:      entry,
+		send:       make(chan consoleMessage, consoleSendQueue),
+	}
+	if !s.add(client) {
+		conn.Close()
+		return
+	}
+	go client.writePump()
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		s.input(client, data)
+	}
+	s.remove(client)
+}
+
+// add makes client a writer if it wants to and no one writes, a reader otherwise.
+// It tells whether the session was still open.
+func (s *consoleSession) add(client *consoleClient) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.clients = append(s.clients, client)
+	if client.wantsWrite && s.writer == nil {
+		s.setWriter(client)
+	} else {
+		client.sendMode(ConsoleModeRead)
+	}
+	return true
+}
+
+// remove disconnects client, handing the writer role over to the next client wanting it.
+func (s *consoleSession) remove(client *consoleClient) {
+	var entry *models.AuditEntry
+	s.mu.Lock()
+	s.removeLocked(client, websocket.CloseNormalClosure, "")
+	if s.writer == client {
+		entry = client.stopWriting()
+		s.writer = nil
+		for _, next := range s.clients {
+			if next.wantsWrite {
+				s.setWriter(next)
+				break
+			}
+		}
+	}
+	s.mu.Unlock()
+	if entry != nil {
+		audit.Record(entry)
+	}
+}
+
+func (s *consoleSession) removeLocked(client *consoleClient, code int, reason string) {
+	for i, c := range s.clients {
+		if c == client {
+			s.clients = append(s.clients[:i], s.clients[i+1:]...)
+			client.closeWith(code, reason)
+			return
+		}
+	}
+}
+
+func (s *consoleSession) setWriter(client *consoleClient) {
+	s.writer = client
+	client.entry.Time = time.Now()
+	client.sendMode(ConsoleModeWrite)
+}
+
+// input sends data typed by client to the console, if client is the writer.
+func (s *consoleSession) input(client *consoleClient, data []byte) {
+	s.mu.Lock()
+	writer := s.writer == client
+	s.mu.Unlock()
+	if writer {
+		s.active()
+		s.stream.Write(data)
+	}
+}
+
+// Write sends the output of the console to all the clients. Clients too slow to keep up
+// are dropped rather than holding the others back.
+func (s *consoleSession) Write(p []byte) (int, error) {
+	s.active()
+	data := append([]byte(nil), p...)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var slow []*consoleClient
+	for _, client := range s.clients {
+		if !client.queue(consoleMessage{websocket.BinaryMessage, data}) {
+			slow = append(slow, client)
+		}
+	}
+	for _, client := range slow {
+		s.removeLocked(client, websocket.ClosePolicyViolation, "Too slow to follow the console.")
+	}
+	if s.writer != nil {
+		s.writer.record(data)
+	}
+	return len(p), nil
+}
+
+type consoleMessage struct {
+	messageType int
+	data        []byte
+}
+
+// consoleClient is a websocket connected to a console session. Its fields besides conn
+// are guarded by the session.
+type consoleClient struct {
+	conn       *websocket.Conn
+	wantsWrite bool
+	send       chan consoleMessage
+	// closeCode and closeReason are sent to the client once send is closed
+	closeCode   int
+	closeReason string
+	sendClosed  bool
+	// entry is the audit entry of the client, recorded when it stops writing
+	entry      *models.AuditEntry
+	transcript bytes.Buffer
+}
+
+// queue sends message to the client unless it lags too far behind.
+func (c *consoleClient) queue(message consoleMessage) bool {
+	if c.sendClosed {
+		return true
+	}
+	select {
+	case c.send <- message:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *consoleClient) sendMode(mode string) {
+	data, _ := json.Marshal(JsonConsoleMode{Mode: mode})
+	c.queue(consoleMessage{websocket.TextMessage, data})
+}
+
+// closeWith makes the write pump close the websocket once the queued messages are sent.
+func (c *consoleClient) closeWith(code int, reason string) {
+	if c.sendClosed {
+		return
+	}
+	c.closeCode, c.closeReason = code, reason
+	c.sendClosed = true
+	close(c.send)
+}
+
+// record appends the output of the console to the transcript of the writer, if enabled.
+func (c *consoleClient) record(data []byte) {
+	if !beego.AppConfig.DefaultBool("consoletranscript", false) {
+		return
+	}
+	if room := consoleTranscriptLimit - c.transcript.Len(); room > 0 {
+		if len(data) > room {
+			data = data[:room]
+		}
+		c.transcript.Write(data)
+	}
+}
+
+// stopWriting returns the audit entry of the client as a writer, with its transcript.
+func (c *consoleClient) stopWriting() *models.AuditEntry {
+	entry := *c.entry
+	entry.Transcript = c.transcript.String()
+	if c.transcript.Len() >= consoleTranscriptLimit {
+		entry.Transcript += "\n[transcript truncated]"
+	}
+	c.transcript.Reset()
+	return &entry
+}
+
+// writePump sends the queued messages to the client and pings it while it is idle.
+func (c *consoleClient) writePump() {
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+	defer c.conn.Close()
+	for {
+		select {
+		case message, ok := <-c.send:
+			if !ok {
+				c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(c.closeCode, c.closeReason),
+					time.Now().Add(time.Second))
+				return
+			}
+			c.conn.SetWriteDeadline(time.Now().Add(wsPingInterval))
+			if err := c.conn.WriteMessage(message.messageType, message.data); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsPingInterval)); err != nil {
+				return
+			}
+		}
+	}
+}
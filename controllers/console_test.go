@@ -0,0 +1,123 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"virt-webui/controllers/audit"
+	"virt-webui/models"
+
+	"github.com/astaxie/beego"
+	"github.com/gorilla/websocket"
+	"kubevirt.io/client-go/kubecli"
+)
+
+// memorySink keeps the audit entries in memory.
+type memorySink struct {
+	sync.Mutex
+	entries []models.AuditEntry
+}
+
+func (s *memorySink) Record(entry *models.AuditEntry) error {
+	s.Lock()
+	defer s.Unlock()
+	s.entries = append(s.entries, *entry)
+	return nil
+}
+
+func (s *memorySink) Query(filter audit.Filter) ([]models.AuditEntry, error) {
+	s.Lock()
+	defer s.Unlock()
+	return append([]models.AuditEntry(nil), s.entries...), nil
+}
+
+func TestConsoleSession(t *testing.T) {
+	sink := &memorySink{}
+	audit.SetSink(sink)
+	defer audit.SetSink(nil)
+	beego.AppConfig.Set("consoletranscript", "true")
+	defer beego.AppConfig.Set("consoletranscript", "false")
+
+	connects := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, _, err := consoleSessions.join("test/ns/vm", func() (kubecli.StreamInterface, error) {
+			connects++
+			return echoStream{}, nil
+		})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer consoleSessions.leave(session)
+		conn, err := wsUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		user := r.URL.Query().Get("user")
+		session.serve(conn, r.URL.Query().Get("mode") != ConsoleModeRead, &models.AuditEntry{User: user, Action: "Console"})
+	}))
+	defer server.Close()
+
+	dial := func(query string) *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"?"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		return conn
+	}
+	expect := func(conn *websocket.Conn, messageType int, data string) {
+		t.Helper()
+		gotType, got, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gotType != messageType || string(got) != data {
+			t.Errorf("got %d %q, expected %d %q", gotType, got, messageType, data)
+		}
+	}
+
+	alice := dial("user=alice")
+	expect(alice, websocket.TextMessage, `{"Mode":"write"}`)
+	bob := dial("user=bob")
+	expect(bob, websocket.TextMessage, `{"Mode":"read"}`)
+	carol := dial("user=carol&mode=read")
+	expect(carol, websocket.TextMessage, `{"Mode":"read"}`)
+	if connects != 1 {
+		t.Errorf("got %d connections to the console, expected 1", connects)
+	}
+
+	// Only the writer types in the console, everyone sees the output
+	bob.WriteMessage(websocket.BinaryMessage, []byte("rm -rf /\n"))
+	alice.WriteMessage(websocket.BinaryMessage, []byte("ls\n"))
+	for _, conn := range []*websocket.Conn{alice, bob, carol} {
+		expect(conn, websocket.BinaryMessage, "ls\n")
+	}
+
+	// The writer role goes to the next client wanting it
+	alice.Close()
+	expect(bob, websocket.TextMessage, `{"Mode":"write"}`)
+	bob.WriteMessage(websocket.BinaryMessage, []byte("uptime\n"))
+	expect(carol, websocket.BinaryMessage, "uptime\n")
+	bob.Close()
+	carol.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		entries, _ := sink.Query(audit.Filter{})
+		if len(entries) == 2 {
+			if entries[0].User != "alice" || entries[0].Transcript != "ls\n" ||
+				entries[1].User != "bob" || entries[1].Transcript != "uptime\n" {
+				t.Errorf("got audit entries %+v", entries)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got audit entries %+v, expected alice and bob", entries)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
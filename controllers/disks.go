@@ -0,0 +1,510 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	imageupload "virt-webui/controllers/imageUpload"
+	"virt-webui/models"
+
+	k8sv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	k8smetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	v1 "kubevirt.io/client-go/api/v1"
+	"kubevirt.io/client-go/kubecli"
+	cdiv1 "kubevirt.io/containerized-data-importer/pkg/apis/core/v1alpha1"
+)
+
+// The types of the disks of a VM
+const (
+	DiskTypeDisk   = "Disk"
+	DiskTypeCDROM  = "CDROM"
+	DiskTypeLUN    = "LUN"
+	DiskTypeFloppy = "Floppy"
+)
+
+// diskBuses are the buses a disk type can be attached to, the first one is the default.
+var diskBuses = map[string][]string{
+	DiskTypeDisk:  {"virtio", "sata", "scsi"},
+	DiskTypeCDROM: {"sata", "scsi"},
+}
+
+// @Title List VM Disk
+// @Description List the disks of a virtual machine.
+// @Param	VMName	path	string	true	"The VM whose disks you want to list"
+// @Success 200 {object} controllers.JsonResponseListDiskSuccess
+// @Failure 403 Forbidden by the RBAC rules of the cluster.
+// @Failure 404 VM not found.
+// @Failure 500 Failed to list disks.
+// @router /:VMName/disks [get]
+func (v *VMController) ListDisks() {
+	namespace, virtClient, err := v.GetVirtClient()
+	if err != nil {
+		v.ResponseNotAvaliable(err)
+		return
+	}
+
+	vmName := v.Ctx.Input.Param(":VMName")
+	vm, err := (*virtClient).VirtualMachine(*namespace).Get(vmName, &k8smetav1.GetOptions{})
+	if err != nil {
+		v.SetError("Failed to list disks of "+vmName+".", err)
+		v.ServeJSON()
+		return
+	}
+	// The sizes are best effort, a disk is still listed without
+	pvcs := map[string]*k8sv1.PersistentVolumeClaim{}
+	if pvcList, err := (*virtClient).CoreV1().PersistentVolumeClaims(*namespace).List(k8smetav1.ListOptions{}); err == nil {
+		for i := range pvcList.Items {
+			pvcs[pvcList.Items[i].Name] = &pvcList.Items[i]
+		}
+	}
+	v.Data["json"] = JsonResponseListDiskSuccess{200, "Disks list success.", newDisks(vm, pvcs)}
+	v.ServeJSON()
+}
+
+type JsonResponseListDiskSuccess struct {
+	StatusCode int
+	Message    string
+	Disks      []models.Disk
+}
+
+// @Title Add VM Disk
+// @Description Add a blank data disk to a virtual machine. The disk is a DataVolume of the VM, created empty. A running VM sees the disk once restarted.
+// @Param	VMName	path	string	true	"The VM you want to add a disk to"
+// @Param	body	body	controllers.JsonRequestAddDisk	true	"The disk"
+// @Success 200 {object} controllers.JsonResponseDiskSuccess
+// @Failure 400 Bad disk request.
+// @Failure 403 Forbidden by the RBAC rules of the cluster.
+// @Failure 404 VM not found.
+// @Failure 409 VM already has a disk of this name, or its DataVolume already exists.
+// @Failure 500 Failed to add disk.
+// @router /:VMName/disks [post]
+func (v *VMController) AddDisk() {
+	namespace, virtClient, err := v.GetVirtClient()
+	if err != nil {
+		v.ResponseNotAvaliable(err)
+		return
+	}
+
+	vmName := v.Ctx.Input.Param(":VMName")
+	var jsonReq JsonRequestAddDisk
+	json.Unmarshal(v.Ctx.Input.RequestBody, &jsonReq)
+	disk, template, err := newBlankDisk(vmName, &jsonReq)
+	if err != nil {
+		v.SetError("Bad disk request.", badRequest(err))
+		v.ServeJSON()
+		return
+	}
+
+	// The DataVolumeTemplate would take over a DataVolume of the same name, like an image.
+	_, err = (*virtClient).CdiClient().CdiV1alpha1().DataVolumes(*namespace).Get(template.Name, k8smetav1.GetOptions{})
+	if err == nil {
+		err = newAPIError(http.StatusConflict, ErrCodeAlreadyExists, "DataVolume %s already exists.", template.Name)
+	} else if k8serrors.IsNotFound(err) {
+		err = nil
+	}
+	var restartRequired bool
+	if err == nil {
+		restartRequired, err = updateVMDisks(*virtClient, *namespace, vmName, func(vm *v1.VirtualMachine) error {
+			volume := v1.Volume{
+				Name:         disk.Name,
+				VolumeSource: v1.VolumeSource{DataVolume: &v1.DataVolumeSource{Name: template.Name}},
+			}
+			if err := addDisk(vm, disk, volume); err != nil {
+				return err
+			}
+			vm.Spec.DataVolumeTemplates = append(vm.Spec.DataVolumeTemplates, *template)
+			return nil
+		})
+	}
+	v.diskResponse("add", vmName, disk.Name, restartRequired, err)
+}
+
+type JsonRequestAddDisk struct {
+	Name string
+	Size string
+	// Bus is virtio (the default), sata or scsi
+	Bus string
+	// StorageClass of the disk, the imagestorageclass of app.conf if empty
+	StorageClass string
+}
+
+type JsonResponseDiskSuccess struct {
+	StatusCode int
+	Message    string
+	// RestartRequired tells that the VM is running, and sees the change once restarted
+	RestartRequired bool
+}
+
+// @Title Attach VM Disk
+// @Description Attach an image to a virtual machine as a read only disk, a writable one if no other VM uses the image, or a read only CD-ROM like an installer ISO. A running VM sees the disk once restarted.
+// @Param	VMName	path	string	true	"The VM you want to attach an image to"
+// @Param	body	body	controllers.JsonRequestAttachDisk	true	"The disk"
+// @Success 200 {object} controllers.JsonResponseDiskSuccess
+// @Failure 400 Bad disk request.
+// @Failure 403 Forbidden by the RBAC rules of the cluster.
+// @Failure 404 VM or image not found.
+// @Failure 409 VM already has a disk of this name, the image is a disk of another VM or written by one, or another VM uses the image of a writable disk.
+// @Failure 500 Failed to attach disk.
+// @router /:VMName/disks/attach [post]
+func (v *VMController) AttachDisk() {
+	namespace, virtClient, err := v.GetVirtClient()
+	if err != nil {
+		v.ResponseNotAvaliable(err)
+		return
+	}
+
+	vmName := v.Ctx.Input.Param(":VMName")
+	var jsonReq JsonRequestAttachDisk
+	json.Unmarshal(v.Ctx.Input.RequestBody, &jsonReq)
+	disk, err := newImageDisk(&jsonReq)
+	if err != nil {
+		v.SetError("Bad disk request.", badRequest(err))
+		v.ServeJSON()
+		return
+	}
+
+	dv, err := (*virtClient).CdiClient().CdiV1alpha1().DataVolumes(*namespace).Get(jsonReq.Image, k8smetav1.GetOptions{})
+	var vmList *v1.VirtualMachineList
+	if err == nil {
+		vmList, err = (*virtClient).VirtualMachine(*namespace).List(&k8smetav1.ListOptions{})
+	}
+	if err == nil {
+		err = canAttach(dv, vmName, jsonReq.Writable, vmList.Items)
+	}
+	var restartRequired bool
+	if err == nil {
+		restartRequired, err = updateVMDisks(*virtClient, *namespace, vmName, func(vm *v1.VirtualMachine) error {
+			return addDisk(vm, disk, v1.Volume{
+				Name:         disk.Name,
+				VolumeSource: v1.VolumeSource{DataVolume: &v1.DataVolumeSource{Name: jsonReq.Image}},
+			})
+		})
+	}
+	v.diskResponse("attach", vmName, disk.Name, restartRequired, err)
+}
+
+type JsonRequestAttachDisk struct {
+	Name  string
+	Image string
+	// Type is Disk (the default) or CDROM
+	Type string
+	// Bus is virtio (the default of disks), sata (the default of CD-ROMs) or scsi
+	Bus string
+	// Writable attaches a disk writable, which only one VM may use an image as. CD-ROMs are read only.
+	Writable bool
+}
+
+// @Title Detach VM Disk
+// @Description Detach a disk from a virtual machine. The data of the disk is kept unless deletedata is set, which is only allowed for the disks added to the VM. A running VM keeps the disk until restarted.
+// @Param	VMName	path	string	true	"The VM you want to detach a disk from"
+// @Param	DiskName	path	string	true	"The disk you want to detach"
+// @Param	deletedata	query	bool	false	"Also delete the DataVolume of a disk added to the VM"
+// @Success 200 {object} controllers.JsonResponseDiskSuccess
+// @Failure 400 Bad disk request.
+// @Failure 403 Forbidden by the RBAC rules of the cluster.
+// @Failure 404 VM or disk not found.
+// @Failure 500 Failed to detach disk.
+// @router /:VMName/disks/:DiskName [delete]
+func (v *VMController) DetachDisk() {
+	namespace, virtClient, err := v.GetVirtClient()
+	if err != nil {
+		v.ResponseNotAvaliable(err)
+		return
+	}
+
+	vmName := v.Ctx.Input.Param(":VMName")
+	name := v.Ctx.Input.Param(":DiskName")
+	deleteData, _ := v.GetBool("deletedata")
+	var template *cdiv1.DataVolume
+	restartRequired, err := updateVMDisks(*virtClient, *namespace, vmName, func(vm *v1.VirtualMachine) error {
+		var err error
+		template, err = removeDisk(vm, name)
+		if err == nil && deleteData && template == nil {
+			err = newAPIError(http.StatusBadRequest, ErrCodeBadRequest, "The data of %s is not owned by %s, detach it without deletedata.", name, vmName)
+		}
+		return err
+	})
+	if err == nil && deleteData {
+		err = (*virtClient).CdiClient().CdiV1alpha1().DataVolumes(*namespace).Delete(template.Name, &k8smetav1.DeleteOptions{})
+		if k8serrors.IsNotFound(err) {
+			err = nil
+		}
+	}
+	v.diskResponse("detach", vmName, name, restartRequired, err)
+}
+
+// diskResponse reports the outcome of the disk operation verb on the disk name of the VM vmName.
+func (v *VMController) diskResponse(verb, vmName, name string, restartRequired bool, err error) {
+	if err == nil {
+		message := vmName + " " + verb + " disk " + name + " success."
+		if restartRequired {
+			message += " Restart " + vmName + " to apply it."
+		}
+		v.Data["json"] = JsonResponseDiskSuccess{200, message, restartRequired}
+	} else {
+		v.SetError("Failed to "+verb+" disk "+name+" of "+vmName+".", err)
+	}
+	v.ServeJSON()
+}
+
+// updateVMDisks applies update to the VM vmName and saves it. It tells whether the VM is running, and so
+// needs a restart for the update to apply.
+func updateVMDisks(client kubecli.KubevirtClient, namespace, vmName string, update func(vm *v1.VirtualMachine) error) (bool, error) {
+	vm, err := client.VirtualMachine(namespace).Get(vmName, &k8smetav1.GetOptions{})
+	if err != nil {
+		return false, err
+	}
+	if vm.Spec.Template == nil {
+		return false, newAPIError(http.StatusConflict, ErrCodeConflict, "%s has no template.", vmName)
+	}
+	if err := update(vm); err != nil {
+		return false, err
+	}
+	if _, err := client.VirtualMachine(namespace).Update(vm); err != nil {
+		return false, err
+	}
+	return vmStopped(client, namespace, vmName) != nil, nil
+}
+
+// newBlankDisk validates jsonReq and returns the disk and the DataVolumeTemplate creating its blank volume.
+// The storage settings default to the image* settings of app.conf.
+func newBlankDisk(vmName string, jsonReq *JsonRequestAddDisk) (v1.Disk, *cdiv1.DataVolume, error) {
+	disk, err := newDisk(jsonReq.Name, DiskTypeDisk, jsonReq.Bus)
+	if err != nil {
+		return disk, nil, err
+	}
+	if jsonReq.Size == "" {
+		return disk, nil, fmt.Errorf("Size is required")
+	}
+	quantity, err := resource.ParseQuantity(jsonReq.Size)
+	if err != nil {
+		return disk, nil, fmt.Errorf("validation failed for size=%s: %s", jsonReq.Size, err)
+	}
+	var accessMode, volumeMode string
+	if err := storageSettings(&jsonReq.StorageClass, &accessMode, &volumeMode); err != nil {
+		return disk, nil, err
+	}
+
+	return disk, &cdiv1.DataVolume{
+		ObjectMeta: k8smetav1.ObjectMeta{
+			Name: vmName + "-" + disk.Name,
+		},
+		Spec: cdiv1.DataVolumeSpec{
+			Source: cdiv1.DataVolumeSource{Blank: &cdiv1.DataVolumeBlankImage{}},
+			PVC:    imageupload.NewPVCSpec(quantity, accessMode, jsonReq.StorageClass, volumeMode),
+		},
+	}, nil
+}
+
+// newImageDisk validates jsonReq and returns the disk attaching its image.
+func newImageDisk(jsonReq *JsonRequestAttachDisk) (v1.Disk, error) {
+	if jsonReq.Image == "" {
+		return v1.Disk{}, fmt.Errorf("Image is required")
+	}
+	if jsonReq.Type == "" {
+		jsonReq.Type = DiskTypeDisk
+	}
+	if jsonReq.Writable && jsonReq.Type != DiskTypeDisk {
+		return v1.Disk{}, fmt.Errorf("a %s cannot be writable", jsonReq.Type)
+	}
+	disk, err := newDisk(jsonReq.Name, jsonReq.Type, jsonReq.Bus)
+	if err == nil && disk.Disk != nil {
+		disk.Disk.ReadOnly = !jsonReq.Writable
+	}
+	return disk, err
+}
+
+// canAttach returns a Conflict error unless the VM vmName may attach the DataVolume dv, writable or not: dv must not be
+// a disk of another VM nor written by one, and a writable disk must not share its image with the other vms.
+func canAttach(dv *cdiv1.DataVolume, vmName string, writable bool, vms []v1.VirtualMachine) error {
+	if owner := ownerVM(dv); owner != "" && owner != vmName {
+		return newAPIError(http.StatusConflict, ErrCodeConflict, "%s is a disk of the VM %s.", dv.Name, owner)
+	}
+	var writers, readers []string
+	for i := range vms {
+		if vms[i].Name == vmName || len(imageConsumers(dv.Name, vms[i:i+1])) == 0 {
+			continue
+		}
+		if readsOnly(&vms[i], dv.Name) {
+			readers = append(readers, vms[i].Name)
+		} else {
+			writers = append(writers, vms[i].Name)
+		}
+	}
+	if len(writers) > 0 {
+		return newAPIError(http.StatusConflict, ErrCodeConflict, "%s is written by the VMs %s.", dv.Name, strings.Join(writers, ", "))
+	}
+	if writable && len(readers) > 0 {
+		return newAPIError(http.StatusConflict, ErrCodeConflict, "%s is used by the VMs %s, attach it read only.", dv.Name, strings.Join(readers, ", "))
+	}
+	return nil
+}
+
+// readsOnly tells whether all the disks of vm backed by the DataVolume or PVC name are read only.
+// A volume of name without a disk, like a filesystem, counts as written.
+func readsOnly(vm *v1.VirtualMachine, name string) bool {
+	found := false
+	for _, disk := range newDisks(vm, nil) {
+		if disk.DataVolume == name || disk.PVC == name {
+			if !disk.ReadOnly {
+				return false
+			}
+			found = true
+		}
+	}
+	return found
+}
+
+// newDisk returns the disk name of diskType on bus, the default bus of diskType if empty.
+// CD-ROMs are read only.
+func newDisk(name, diskType, bus string) (v1.Disk, error) {
+	disk := v1.Disk{Name: name}
+	if name == "" {
+		return disk, fmt.Errorf("Name is required")
+	}
+	if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+		return disk, fmt.Errorf("Name %s is invalid: %s", name, strings.Join(errs, ", "))
+	}
+	buses, ok := diskBuses[diskType]
+	if !ok {
+		return disk, fmt.Errorf("unknown Type %s", diskType)
+	}
+	if bus == "" {
+		bus = buses[0]
+	}
+	known := false
+	for _, b := range buses {
+		known = known || b == bus
+	}
+	if !known {
+		return disk, fmt.Errorf("a %s cannot use the bus %s", diskType, bus)
+	}
+
+	if diskType == DiskTypeCDROM {
+		readOnly := true
+		disk.CDRom = &v1.CDRomTarget{Bus: bus, ReadOnly: &readOnly}
+	} else {
+		disk.Disk = &v1.DiskTarget{Bus: bus}
+	}
+	return disk, nil
+}
+
+// addDisk adds disk and the volume backing it to vm.
+func addDisk(vm *v1.VirtualMachine, disk v1.Disk, volume v1.Volume) error {
+	spec := &vm.Spec.Template.Spec
+	for _, d := range spec.Domain.Devices.Disks {
+		if d.Name == disk.Name {
+			return newAPIError(http.StatusConflict, ErrCodeAlreadyExists, "%s already has a disk %s.", vm.Name, disk.Name)
+		}
+	}
+	for _, v := range spec.Volumes {
+		if v.Name == volume.Name {
+			return newAPIError(http.StatusConflict, ErrCodeAlreadyExists, "%s already has a volume %s.", vm.Name, volume.Name)
+		}
+	}
+	if volume.DataVolume != nil && hasDataVolumeTemplate(vm, volume.DataVolume.Name) {
+		return newAPIError(http.StatusConflict, ErrCodeAlreadyExists, "%s already has a DataVolume %s.", vm.Name, volume.DataVolume.Name)
+	}
+	spec.Domain.Devices.Disks = append(spec.Domain.Devices.Disks, disk)
+	spec.Volumes = append(spec.Volumes, volume)
+	return nil
+}
+
+// removeDisk removes the disk name and its volume from vm, along with the DataVolumeTemplate creating the volume.
+// It returns that template, nil if the volume was not created for vm. The root disk cannot be removed.
+func removeDisk(vm *v1.VirtualMachine, name string) (*cdiv1.DataVolume, error) {
+	if name == rootDiskName {
+		return nil, newAPIError(http.StatusBadRequest, ErrCodeBadRequest, "%s is the root disk of %s.", name, vm.Name)
+	}
+	spec := &vm.Spec.Template.Spec
+	found := false
+	for i, disk := range spec.Domain.Devices.Disks {
+		if disk.Name == name {
+			spec.Domain.Devices.Disks = append(spec.Domain.Devices.Disks[:i], spec.Domain.Devices.Disks[i+1:]...)
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, newAPIError(http.StatusNotFound, ErrCodeNotFound, "%s has no disk %s.", vm.Name, name)
+	}
+
+	var template *cdiv1.DataVolume
+	for i, volume := range spec.Volumes {
+		if volume.Name != name {
+			continue
+		}
+		spec.Volumes = append(spec.Volumes[:i], spec.Volumes[i+1:]...)
+		if volume.DataVolume == nil {
+			break
+		}
+		templates := vm.Spec.DataVolumeTemplates
+		for j := range templates {
+			if templates[j].Name == volume.DataVolume.Name {
+				template = templates[j].DeepCopy()
+				vm.Spec.DataVolumeTemplates = append(templates[:j], templates[j+1:]...)
+				break
+			}
+		}
+		break
+	}
+	return template, nil
+}
+
+// newDisks returns the disks of vm, with the sizes of their volumes taken from pvcs.
+func newDisks(vm *v1.VirtualMachine, pvcs map[string]*k8sv1.PersistentVolumeClaim) []models.Disk {
+	var disks []models.Disk
+	if vm.Spec.Template == nil {
+		return disks
+	}
+	volumes := map[string]*v1.Volume{}
+	for i := range vm.Spec.Template.Spec.Volumes {
+		volumes[vm.Spec.Template.Spec.Volumes[i].Name] = &vm.Spec.Template.Spec.Volumes[i]
+	}
+
+	for _, d := range vm.Spec.Template.Spec.Domain.Devices.Disks {
+		disk := models.Disk{Name: d.Name}
+		switch {
+		case d.Disk != nil:
+			disk.Type, disk.Bus, disk.ReadOnly = DiskTypeDisk, d.Disk.Bus, d.Disk.ReadOnly
+		case d.CDRom != nil:
+			disk.Type, disk.Bus = DiskTypeCDROM, d.CDRom.Bus
+			disk.ReadOnly = d.CDRom.ReadOnly == nil || *d.CDRom.ReadOnly
+		case d.LUN != nil:
+			disk.Type, disk.Bus, disk.ReadOnly = DiskTypeLUN, d.LUN.Bus, d.LUN.ReadOnly
+		case d.Floppy != nil:
+			disk.Type, disk.ReadOnly = DiskTypeFloppy, d.Floppy.ReadOnly
+		}
+
+		var claim string
+		if volume := volumes[d.Name]; volume != nil && volume.DataVolume != nil {
+			disk.DataVolume, claim = volume.DataVolume.Name, volume.DataVolume.Name
+			template := dataVolumeTemplate(vm, claim)
+			switch {
+			case template == nil:
+				// An image attached as is
+				disk.Image = claim
+			case template.Spec.Source.PVC != nil:
+				disk.Image = template.Spec.Source.PVC.Name
+			}
+			if template != nil && template.Spec.PVC != nil {
+				if size, ok := template.Spec.PVC.Resources.Requests[k8sv1.ResourceStorage]; ok {
+					disk.Size = size.String()
+				}
+			}
+		} else if volume != nil && volume.PersistentVolumeClaim != nil {
+			disk.PVC, claim = volume.PersistentVolumeClaim.ClaimName, volume.PersistentVolumeClaim.ClaimName
+		}
+		if pvc := pvcs[claim]; pvc != nil {
+			if capacity, ok := pvc.Status.Capacity[k8sv1.ResourceStorage]; ok {
+				disk.Size = capacity.String()
+			}
+		}
+		disks = append(disks, disk)
+	}
+	return disks
+}
@@ -0,0 +1,208 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+	"virt-webui/models"
+
+	k8sv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	k8smetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1 "kubevirt.io/client-go/api/v1"
+	cdiv1 "kubevirt.io/containerized-data-importer/pkg/apis/core/v1alpha1"
+)
+
+func TestNewDisk(t *testing.T) {
+	for _, test := range []struct {
+		name, diskType, bus string
+		expectedBus         string
+		expectedErr         bool
+	}{
+		{name: "data", diskType: DiskTypeDisk, expectedBus: "virtio"},
+		{name: "data", diskType: DiskTypeDisk, bus: "scsi", expectedBus: "scsi"},
+		{name: "installer", diskType: DiskTypeCDROM, expectedBus: "sata"},
+		{name: "installer", diskType: DiskTypeCDROM, bus: "virtio", expectedErr: true},
+		{name: "data", diskType: "Tape", expectedErr: true},
+		{name: "Data_1", diskType: DiskTypeDisk, expectedErr: true},
+	} {
+		disk, err := newDisk(test.name, test.diskType, test.bus)
+		if test.expectedErr {
+			if err == nil {
+				t.Errorf("%s %s on %q: got %+v, expected an error", test.diskType, test.name, test.bus, disk)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %s on %q: %v", test.diskType, test.name, test.bus, err)
+			continue
+		}
+		switch {
+		case test.diskType == DiskTypeCDROM && (disk.CDRom == nil || disk.CDRom.Bus != test.expectedBus || !*disk.CDRom.ReadOnly):
+			t.Errorf("got CD-ROM %+v, expected a read only one on %s", disk.CDRom, test.expectedBus)
+		case test.diskType == DiskTypeDisk && (disk.Disk == nil || disk.Disk.Bus != test.expectedBus):
+			t.Errorf("got disk %+v, expected one on %s", disk.Disk, test.expectedBus)
+		}
+	}
+}
+
+func TestAddRemoveDisk(t *testing.T) {
+	vm := &v1.VirtualMachine{
+		ObjectMeta: k8smetav1.ObjectMeta{Name: "vm", Namespace: "ns"},
+		Spec: v1.VirtualMachineSpec{
+			Template: &v1.VirtualMachineInstanceTemplateSpec{
+				Spec: v1.VirtualMachineInstanceSpec{
+					Domain: v1.DomainSpec{
+						Devices: v1.Devices{
+							Disks: []v1.Disk{{Name: rootDiskName, DiskDevice: v1.DiskDevice{Disk: &v1.DiskTarget{Bus: "virtio"}}}},
+						},
+					},
+					Volumes: []v1.Volume{{
+						Name:         rootDiskName,
+						VolumeSource: v1.VolumeSource{DataVolume: &v1.DataVolumeSource{Name: "ubuntu"}},
+					}},
+				},
+			},
+		},
+	}
+
+	data, template, err := newBlankDisk("vm", &JsonRequestAddDisk{Name: "data", Size: "10Gi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := addDisk(vm, data, v1.Volume{
+		Name:         data.Name,
+		VolumeSource: v1.VolumeSource{DataVolume: &v1.DataVolumeSource{Name: template.Name}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	vm.Spec.DataVolumeTemplates = append(vm.Spec.DataVolumeTemplates, *template)
+	installer, _ := newDisk("installer", DiskTypeCDROM, "")
+	if err := addDisk(vm, installer, v1.Volume{
+		Name:         installer.Name,
+		VolumeSource: v1.VolumeSource{DataVolume: &v1.DataVolumeSource{Name: "ubuntu-iso"}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := addDisk(vm, installer, v1.Volume{Name: installer.Name}); toAPIError(err).Code != ErrCodeAlreadyExists {
+		t.Errorf("adding a disk twice: got %v, expected AlreadyExists", err)
+	}
+
+	pvcs := map[string]*k8sv1.PersistentVolumeClaim{
+		"ubuntu": {Status: k8sv1.PersistentVolumeClaimStatus{
+			Capacity: k8sv1.ResourceList{k8sv1.ResourceStorage: resource.MustParse("5Gi")},
+		}},
+	}
+	expected := []models.Disk{
+		{Name: rootDiskName, Type: DiskTypeDisk, Bus: "virtio", DataVolume: "ubuntu", Image: "ubuntu", Size: "5Gi"},
+		{Name: "data", Type: DiskTypeDisk, Bus: "virtio", DataVolume: "vm-data", Size: "10Gi"},
+		{Name: "installer", Type: DiskTypeCDROM, Bus: "sata", ReadOnly: true, DataVolume: "ubuntu-iso", Image: "ubuntu-iso"},
+	}
+	if got := newDisks(vm, pvcs); !reflect.DeepEqual(got, expected) {
+		t.Errorf("got disks %+v, expected %+v", got, expected)
+	}
+
+	if _, err := removeDisk(vm, rootDiskName); err == nil {
+		t.Errorf("removed the root disk")
+	}
+	if removed, err := removeDisk(vm, "installer"); err != nil || removed != nil {
+		t.Errorf("removing the image: got %v, %v, expected no DataVolumeTemplate", removed, err)
+	}
+	if removed, err := removeDisk(vm, "data"); err != nil || removed == nil || removed.Name != "vm-data" ||
+		removed.Spec.Source.Blank == nil {
+		t.Errorf("removing the blank disk: got %v, %v, expected its DataVolumeTemplate", removed, err)
+	}
+	if _, err := removeDisk(vm, "data"); toAPIError(err).Code != ErrCodeNotFound {
+		t.Errorf("removing a disk twice: got %v, expected NotFound", err)
+	}
+	spec := vm.Spec.Template.Spec
+	if len(spec.Domain.Devices.Disks) != 1 || len(spec.Volumes) != 1 || len(vm.Spec.DataVolumeTemplates) != 0 {
+		t.Errorf("got disks %+v, volumes %+v and DataVolumeTemplates %+v, expected only the root disk",
+			spec.Domain.Devices.Disks, spec.Volumes, vm.Spec.DataVolumeTemplates)
+	}
+}
+
+func TestNewImageDisk(t *testing.T) {
+	for _, test := range []struct {
+		req              JsonRequestAttachDisk
+		expectedReadOnly bool
+		expectedErr      bool
+	}{
+		{req: JsonRequestAttachDisk{Name: "data", Image: "ubuntu"}, expectedReadOnly: true},
+		{req: JsonRequestAttachDisk{Name: "data", Image: "ubuntu", Writable: true}},
+		{req: JsonRequestAttachDisk{Name: "installer", Image: "ubuntu-iso", Type: DiskTypeCDROM}, expectedReadOnly: true},
+		{req: JsonRequestAttachDisk{Name: "installer", Image: "ubuntu-iso", Type: DiskTypeCDROM, Writable: true}, expectedErr: true},
+		{req: JsonRequestAttachDisk{Name: "data"}, expectedErr: true},
+	} {
+		disk, err := newImageDisk(&test.req)
+		if test.expectedErr {
+			if err == nil {
+				t.Errorf("%+v: got %+v, expected an error", test.req, disk)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%+v: %v", test.req, err)
+			continue
+		}
+		if got := newDisks(&v1.VirtualMachine{Spec: v1.VirtualMachineSpec{Template: &v1.VirtualMachineInstanceTemplateSpec{
+			Spec: v1.VirtualMachineInstanceSpec{Domain: v1.DomainSpec{Devices: v1.Devices{Disks: []v1.Disk{disk}}}},
+		}}}, nil)[0].ReadOnly; got != test.expectedReadOnly {
+			t.Errorf("%+v: got read only %v, expected %v", test.req, got, test.expectedReadOnly)
+		}
+	}
+}
+
+func TestCanAttach(t *testing.T) {
+	// usingImage returns the VM name with the image shared as device, or as a volume without a disk if device is nil.
+	usingImage := func(name string, device *v1.DiskDevice) v1.VirtualMachine {
+		vm := v1.VirtualMachine{
+			ObjectMeta: k8smetav1.ObjectMeta{Name: name},
+			Spec: v1.VirtualMachineSpec{Template: &v1.VirtualMachineInstanceTemplateSpec{Spec: v1.VirtualMachineInstanceSpec{
+				Volumes: []v1.Volume{{Name: "data", VolumeSource: v1.VolumeSource{DataVolume: &v1.DataVolumeSource{Name: "shared"}}}},
+			}}},
+		}
+		if device != nil {
+			vm.Spec.Template.Spec.Domain.Devices.Disks = []v1.Disk{{Name: "data", DiskDevice: *device}}
+		}
+		return vm
+	}
+	readOnly := true
+	readOnlyDisk := &v1.DiskDevice{Disk: &v1.DiskTarget{Bus: "virtio", ReadOnly: true}}
+	cdrom := &v1.DiskDevice{CDRom: &v1.CDRomTarget{Bus: "sata", ReadOnly: &readOnly}}
+	writableDisk := &v1.DiskDevice{Disk: &v1.DiskTarget{Bus: "virtio"}}
+	image := &cdiv1.DataVolume{ObjectMeta: k8smetav1.ObjectMeta{Name: "shared"}}
+	disk := &cdiv1.DataVolume{ObjectMeta: k8smetav1.ObjectMeta{
+		Name:            "db-dvdisk",
+		OwnerReferences: []k8smetav1.OwnerReference{{APIVersion: "kubevirt.io/v1alpha3", Kind: "VirtualMachine", Name: "db"}},
+	}}
+
+	for _, test := range []struct {
+		name         string
+		dv           *cdiv1.DataVolume
+		vm           string
+		writable     bool
+		vms          []v1.VirtualMachine
+		expectedCode string
+	}{
+		{name: "unused image", dv: image, vm: "web", writable: true},
+		{name: "read only image read by others", dv: image, vm: "web",
+			vms: []v1.VirtualMachine{usingImage("db", readOnlyDisk), usingImage("cache", cdrom)}},
+		{name: "read only image written by another VM", dv: image, vm: "web",
+			vms: []v1.VirtualMachine{usingImage("db", readOnlyDisk), usingImage("cache", writableDisk)}, expectedCode: ErrCodeConflict},
+		{name: "read only image used by another VM without a disk", dv: image, vm: "web",
+			vms: []v1.VirtualMachine{usingImage("db", nil)}, expectedCode: ErrCodeConflict},
+		{name: "writable image read by another VM", dv: image, vm: "web", writable: true,
+			vms: []v1.VirtualMachine{usingImage("db", readOnlyDisk)}, expectedCode: ErrCodeConflict},
+		{name: "writable image used by the VM itself", dv: image, vm: "web", writable: true,
+			vms: []v1.VirtualMachine{usingImage("web", writableDisk)}},
+		{name: "disk of another VM", dv: disk, vm: "web", expectedCode: ErrCodeConflict},
+		{name: "own disk", dv: disk, vm: "db", writable: true},
+	} {
+		err := canAttach(test.dv, test.vm, test.writable, test.vms)
+		if test.expectedCode == "" && err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if test.expectedCode != "" && toAPIError(err).Code != test.expectedCode {
+			t.Errorf("%s: got %v, expected a %s error", test.name, err, test.expectedCode)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"kubevirt.io/client-go/kubecli"
+)
+
+// Codes of JsonResponseBasic telling clients why a request failed.
+const (
+	ErrCodeBadRequest      = "BadRequest"
+	ErrCodeUnauthorized    = "Unauthorized"
+	ErrCodeForbidden       = "Forbidden"
+	ErrCodeNotFound        = "NotFound"
+	ErrCodeAlreadyExists   = "AlreadyExists"
+	ErrCodeConflict        = "Conflict"
+	ErrCodeInvalid         = "Invalid"
+	ErrCodeTooManyRequests = "TooManyRequests"
+	ErrCodeTimeout         = "Timeout"
+	ErrCodeUnavailable     = "Unavailable"
+	ErrCodeNotImplemented  = "NotImplemented"
+	ErrCodeInternal        = "InternalError"
+)
+
+// statusCodes are the codes of the HTTP statuses errors only report the status of.
+var statusCodes = map[int]string{
+	http.StatusBadRequest:         ErrCodeBadRequest,
+	http.StatusUnauthorized:       ErrCodeUnauthorized,
+	http.StatusForbidden:          ErrCodeForbidden,
+	http.StatusNotFound:           ErrCodeNotFound,
+	http.StatusConflict:           ErrCodeConflict,
+	http.StatusTooManyRequests:    ErrCodeTooManyRequests,
+	http.StatusServiceUnavailable: ErrCodeUnavailable,
+	http.StatusGatewayTimeout:     ErrCodeTimeout,
+}
+
+// APIError is an error together with the HTTP status and code it is reported with.
+type APIError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// newAPIError returns an APIError with a message formatted like fmt.Sprintf.
+func newAPIError(status int, code, format string, a ...interface{}) *APIError {
+	return &APIError{Status: status, Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+// badRequest reports err as a problem with the request itself.
+func badRequest(err error) *APIError {
+	return &APIError{Status: http.StatusBadRequest, Code: ErrCodeBadRequest, Message: err.Error()}
+}
+
+// wrapError prefixes the message of err, keeping the HTTP status and code err maps to.
+func wrapError(err error, format string, a ...interface{}) *APIError {
+	apiErr := *toAPIError(err)
+	apiErr.Message = fmt.Sprintf(format, a...) + ": " + apiErr.Message
+	return &apiErr
+}
+
+// toAPIError maps err, typically returned by the Kubernetes API, to the HTTP status and code it is reported with.
+func toAPIError(err error) *APIError {
+	if apiErr, ok := err.(*APIError); ok {
+		return apiErr
+	}
+	// The websocket subresources of KubeVirt, like VNC, only report the HTTP status
+	if asyncErr, ok := err.(*kubecli.AsyncSubresourceError); ok {
+		message := strings.TrimSpace(err.Error())
+		if asyncErr.StatusCode == 0 {
+			// No response, the cluster could not be reached
+			return &APIError{Status: http.StatusServiceUnavailable, Code: ErrCodeUnavailable, Message: message}
+		}
+		if code, ok := statusCodes[asyncErr.StatusCode]; ok {
+			return &APIError{Status: asyncErr.StatusCode, Code: code, Message: message}
+		}
+	}
+
+	status, code := http.StatusInternalServerError, ErrCodeInternal
+	switch {
+	case k8serrors.IsNotFound(err), os.IsNotExist(err):
+		status, code = http.StatusNotFound, ErrCodeNotFound
+	case k8serrors.IsAlreadyExists(err):
+		status, code = http.StatusConflict, ErrCodeAlreadyExists
+	case k8serrors.IsConflict(err):
+		status, code = http.StatusConflict, ErrCodeConflict
+	case k8serrors.IsForbidden(err), os.IsPermission(err):
+		status, code = http.StatusForbidden, ErrCodeForbidden
+	case k8serrors.IsUnauthorized(err):
+		status, code = http.StatusUnauthorized, ErrCodeUnauthorized
+	case k8serrors.IsInvalid(err):
+		status, code = http.StatusUnprocessableEntity, ErrCodeInvalid
+	case k8serrors.IsBadRequest(err):
+		status, code = http.StatusBadRequest, ErrCodeBadRequest
+	case k8serrors.IsTooManyRequests(err):
+		status, code = http.StatusTooManyRequests, ErrCodeTooManyRequests
+	case k8serrors.IsTimeout(err), k8serrors.IsServerTimeout(err), err == wait.ErrWaitTimeout, err == context.DeadlineExceeded:
+		status, code = http.StatusGatewayTimeout, ErrCodeTimeout
+	case k8serrors.IsServiceUnavailable(err):
+		status, code = http.StatusServiceUnavailable, ErrCodeUnavailable
+	default:
+		if _, ok := err.(net.Error); ok {
+			status, code = http.StatusServiceUnavailable, ErrCodeUnavailable
+		}
+	}
+	return &APIError{Status: status, Code: code, Message: err.Error()}
+}
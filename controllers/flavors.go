@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"virt-webui/models"
+
+	"github.com/astaxie/beego"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// Operations about VM flavor
+type FlavorController struct {
+	beego.Controller
+}
+
+// flavors is the catalog of flavors, set once by LoadFlavors at startup.
+var flavors []models.Flavor
+
+// LoadFlavors reads the flavor catalog from the JSON file at path.
+func LoadFlavors(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var catalog []models.Flavor
+	if err := json.Unmarshal(data, &catalog); err != nil {
+		return fmt.Errorf("cannot parse flavors %s: %v", path, err)
+	}
+	names := map[string]bool{}
+	for _, flavor := range catalog {
+		if flavor.Name == "" || names[flavor.Name] {
+			return fmt.Errorf("flavor names in %s must be set and unique", path)
+		}
+		names[flavor.Name] = true
+		if err := validateFlavor(flavor); err != nil {
+			return fmt.Errorf("flavor %s: %v", flavor.Name, err)
+		}
+	}
+	flavors = catalog
+	return nil
+}
+
+// validateFlavor checks the CPU and memory settings of flavor.
+func validateFlavor(flavor models.Flavor) error {
+	if flavor.Cores == 0 {
+		return fmt.Errorf("Cores must be at least 1")
+	}
+	if flavor.Memory == "" {
+		return fmt.Errorf("Memory must be set")
+	}
+	for _, quantity := range []string{flavor.Memory, flavor.CPULimit, flavor.MemoryLimit} {
+		if quantity == "" {
+			continue
+		}
+		if _, err := resource.ParseQuantity(quantity); err != nil {
+			return fmt.Errorf("invalid quantity %q: %v", quantity, err)
+		}
+	}
+	switch flavor.HugepagesPageSize {
+	case "", "2Mi", "1Gi":
+	default:
+		return fmt.Errorf("HugepagesPageSize must be 2Mi or 1Gi")
+	}
+	return nil
+}
+
+// getFlavor returns the flavor called name.
+func getFlavor(name string) (models.Flavor, bool) {
+	for _, flavor := range flavors {
+		if flavor.Name == name {
+			return flavor, true
+		}
+	}
+	return models.Flavor{}, false
+}
+
+// @Title List Flavor
+// @Description List all VM flavors.
+// @Success 200 {object} controllers.JsonResponseListFlavorSuccess
+// @router / [get]
+func (f *FlavorController) GetAll() {
+	f.Data["json"] = JsonResponseListFlavorSuccess{200, "Flavors list success.", flavors,
+		beego.AppConfig.String("defaultflavor")}
+	f.ServeJSON()
+}
+
+type JsonResponseListFlavorSuccess struct {
+	StatusCode    int
+	Message       string
+	Flavors       []models.Flavor
+	DefaultFlavor string
+}
@@ -0,0 +1,282 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	imageupload "virt-webui/controllers/imageUpload"
+	"virt-webui/models"
+
+	k8sv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	k8smetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"kubevirt.io/client-go/kubecli"
+	cdiv1 "kubevirt.io/containerized-data-importer/pkg/apis/core/v1alpha1"
+)
+
+const (
+	cloneWaitInterval = 2 * time.Second
+	// renameCloneTimeout bounds how long a rename waits for its copy of the image
+	renameCloneTimeout = 30 * time.Minute
+)
+
+// @Title Clone Image
+// @Description Clone an exist image into a new image, in the same or in another namespace. The clone runs in the cluster, list the images for its progress.
+// @Param	ImageName	path 	string	true		"The image you want to clone"
+// @Param	body	body	controllers.JsonRequestCloneImage	true	"The new image"
+// @Success 202 {object} controllers.JsonResponseCloneImageSuccess
+// @Failure 400 Bad clone request.
+// @Failure 404 Image not found.
+// @Failure 500 Failed to clone image.
+// @router /:ImageName/clone [post]
+func (i *ImageController) Clone() {
+	namespace, virtClient, err := i.GetVirtClient()
+	if err != nil {
+		i.ResponseNotAvaliable(err)
+		return
+	}
+
+	imgName := i.Ctx.Input.Param(":ImageName")
+	var jsonReq JsonRequestCloneImage
+	json.Unmarshal(i.Ctx.Input.RequestBody, &jsonReq)
+	if jsonReq.NewName == "" {
+		i.SetError("Bad clone request.", newAPIError(http.StatusBadRequest, ErrCodeBadRequest, "NewName is required."))
+		i.ServeJSON()
+		return
+	}
+	if jsonReq.Namespace == "" {
+		jsonReq.Namespace = *namespace
+	}
+
+	_, err = getImage(*virtClient, *namespace, imgName)
+	var dv *cdiv1.DataVolume
+	if err == nil {
+		dv, err = cloneImage(*virtClient, *namespace, imgName, jsonReq.Namespace, jsonReq.NewName, jsonReq.Size)
+	}
+	if err == nil {
+		i.Ctx.Output.SetStatus(202)
+		i.Data["json"] = JsonResponseCloneImageSuccess{202, "Clone " + imgName + " to " + jsonReq.NewName + " started.",
+			models.Image{Name: dv.Name, Namespace: dv.Namespace}}
+	} else {
+		i.SetError("Failed to clone "+imgName+" to "+jsonReq.NewName+".", err)
+	}
+	i.ServeJSON()
+}
+
+type JsonRequestCloneImage struct {
+	NewName string
+	// Namespace of the new image, the namespace of the cloned image if empty
+	Namespace string
+	// Size of the new image, the size of the cloned image if empty
+	Size string
+}
+
+type JsonResponseCloneImageSuccess struct {
+	StatusCode int
+	Message    string
+	Image      models.Image
+}
+
+// @Title Rename Image
+// @Description Start renaming an exist image that no VM uses. The image is cloned to the new name, the clone is verified and only then the image is deleted. The rename runs in the background, poll the returned job for its progress.
+// @Param	ImageName	path 	string	true		"The image you want to rename"
+// @Param	body	body	controllers.JsonRequestRename	true	"The new name"
+// @Success 202 {object} controllers.JsonResponseRenameImageSuccess
+// @Failure 400 Bad rename request.
+// @Failure 404 Image not found.
+// @Failure 409 Image used by a VM, or new name already exists.
+// @Failure 500 Failed to rename image.
+// @router /:ImageName [put]
+func (i *ImageController) Put() {
+	namespace, virtClient, err := i.GetVirtClient()
+	if err != nil {
+		i.ResponseNotAvaliable(err)
+		return
+	}
+
+	imgName := i.Ctx.Input.Param(":ImageName")
+	var jsonReq JsonRequestRename
+	json.Unmarshal(i.Ctx.Input.RequestBody, &jsonReq)
+	newName := jsonReq.NewName
+	if newName == "" || newName == imgName {
+		i.SetError("Bad rename request.", newAPIError(http.StatusBadRequest, ErrCodeBadRequest, "NewName is required and must differ from the image name."))
+		i.ServeJSON()
+		return
+	}
+
+	if err := startRenameImage(*virtClient, *namespace, imgName, newName); err != nil {
+		i.SetError("Failed to rename "+imgName+" to "+newName+".", err)
+		i.ServeJSON()
+		return
+	}
+	jobID, _ := imageJobs.start(i.jobOwner(), newName, imgName, func(ctx context.Context, progress imageupload.ProgressFunc) error {
+		return finishRenameImage(ctx, *virtClient, *namespace, imgName, newName, progress)
+	})
+
+	i.Ctx.Output.SetStatus(202)
+	i.Data["json"] = JsonResponseRenameImageSuccess{202, "Rename " + imgName + " to " + newName + " started.", newName, jobID}
+	i.ServeJSON()
+}
+
+type JsonResponseRenameImageSuccess struct {
+	StatusCode int
+	Message    string
+	NewName    string
+	JobID      string
+}
+
+// cloneImage creates the DataVolume newName in targetNamespace as a clone of the image name in namespace.
+func cloneImage(client kubecli.KubevirtClient, namespace, name, targetNamespace, newName, size string) (*cdiv1.DataVolume, error) {
+	dv, err := newCloneDataVolume(client, namespace, name, targetNamespace, newName, size)
+	if err != nil {
+		return nil, err
+	}
+	return client.CdiClient().CdiV1alpha1().DataVolumes(targetNamespace).Create(dv)
+}
+
+// newCloneDataVolume returns, without creating it, the DataVolume newName in targetNamespace cloning the PVC name in namespace.
+// The new PVC gets the access modes, volume mode and storage class of the cloned one, and its size unless size is set.
+func newCloneDataVolume(client kubecli.KubevirtClient, namespace, name, targetNamespace, newName, size string) (*cdiv1.DataVolume, error) {
+	pvc, err := client.CoreV1().PersistentVolumeClaims(namespace).Get(name, k8smetav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	quantity := pvc.Spec.Resources.Requests[k8sv1.ResourceStorage]
+	if size != "" {
+		sourceQuantity := quantity
+		quantity, err = resource.ParseQuantity(size)
+		if err != nil {
+			return nil, badRequest(fmt.Errorf("validation failed for size=%s: %s", size, err))
+		}
+		if quantity.Cmp(sourceQuantity) < 0 {
+			return nil, badRequest(fmt.Errorf("size %s is smaller than the %s of %s", size, sourceQuantity.String(), name))
+		}
+	}
+
+	return &cdiv1.DataVolume{
+		ObjectMeta: k8smetav1.ObjectMeta{
+			Name:      newName,
+			Namespace: targetNamespace,
+		},
+		Spec: cdiv1.DataVolumeSpec{
+			Source: cdiv1.DataVolumeSource{
+				PVC: &cdiv1.DataVolumeSourcePVC{
+					Namespace: namespace,
+					Name:      name,
+				},
+			},
+			PVC: &k8sv1.PersistentVolumeClaimSpec{
+				AccessModes:      pvc.Spec.AccessModes,
+				StorageClassName: pvc.Spec.StorageClassName,
+				VolumeMode:       pvc.Spec.VolumeMode,
+				Resources: k8sv1.ResourceRequirements{
+					Requests: k8sv1.ResourceList{
+						k8sv1.ResourceStorage: quantity,
+					},
+				},
+			},
+		},
+	}, nil
+}
+
+// waitCloneSucceeded waits until the DataVolume name has been populated, ctx is done or timeout has passed.
+func waitCloneSucceeded(ctx context.Context, client kubecli.KubevirtClient, namespace, name string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err := wait.PollImmediateUntil(cloneWaitInterval, func() (bool, error) {
+		dv, err := client.CdiClient().CdiV1alpha1().DataVolumes(namespace).Get(name, k8smetav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		if dv.Status.Phase == cdiv1.Failed {
+			return false, fmt.Errorf("clone %s failed", name)
+		}
+		return dv.Status.Phase == cdiv1.Succeeded, nil
+	}, ctx.Done())
+	if err == wait.ErrWaitTimeout && ctx.Err() == context.Canceled {
+		return ctx.Err()
+	}
+	return err
+}
+
+// verifyClone checks that the PVC of clone is bound and at least as large as the PVC of source.
+func verifyClone(client kubecli.KubevirtClient, namespace, source, clone string) error {
+	pvcs := client.CoreV1().PersistentVolumeClaims(namespace)
+	sourcePVC, err := pvcs.Get(source, k8smetav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	clonePVC, err := pvcs.Get(clone, k8smetav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if clonePVC.Status.Phase != k8sv1.ClaimBound {
+		return fmt.Errorf("PVC %s is %s, not Bound", clone, clonePVC.Status.Phase)
+	}
+	sourceSize := sourcePVC.Spec.Resources.Requests[k8sv1.ResourceStorage]
+	cloneSize := clonePVC.Status.Capacity[k8sv1.ResourceStorage]
+	if cloneSize.Cmp(sourceSize) < 0 {
+		return fmt.Errorf("PVC %s has %s, less than the %s of %s", clone, cloneSize.String(), sourceSize.String(), source)
+	}
+	return nil
+}
+
+// startRenameImage checks that name is an image no VM uses and creates its clone newName.
+func startRenameImage(client kubecli.KubevirtClient, namespace, name, newName string) error {
+	if _, err := getImage(client, namespace, name); err != nil {
+		return err
+	}
+	if err := imageNotInUse(client, namespace, name); err != nil {
+		return err
+	}
+	_, err := cloneImage(client, namespace, name, namespace, newName, "")
+	return err
+}
+
+// finishRenameImage waits for the clone newName started by startRenameImage and deletes the image name once the
+// clone is verified. On any failure before the delete the clone is deleted and the image is left untouched.
+func finishRenameImage(ctx context.Context, client kubecli.KubevirtClient, namespace, name, newName string, progress imageupload.ProgressFunc) error {
+	dataVolumes := client.CdiClient().CdiV1alpha1().DataVolumes(namespace)
+	err := func() error {
+		progress(imageupload.Progress{Phase: imageupload.PhaseProcessing})
+		if err := waitCloneSucceeded(ctx, client, namespace, newName, renameCloneTimeout); err == wait.ErrWaitTimeout {
+			return newAPIError(http.StatusGatewayTimeout, ErrCodeTimeout, "%s is kept, cloning to %s did not complete in %v", name, newName, renameCloneTimeout)
+		} else if err != nil {
+			return wrapError(err, "%s is kept, cloning to %s did not complete", name, newName)
+		}
+		if err := verifyClone(client, namespace, name, newName); err != nil {
+			return wrapError(err, "%s is kept, %s could not be verified", name, newName)
+		}
+		// A VM may have started using the image while it was cloned.
+		if err := imageNotInUse(client, namespace, name); err != nil {
+			return wrapError(err, "%s is kept", name)
+		}
+		return nil
+	}()
+	if err != nil {
+		dataVolumes.Delete(newName, &k8smetav1.DeleteOptions{})
+		return err
+	}
+	if err := dataVolumes.Delete(name, &k8smetav1.DeleteOptions{}); err != nil {
+		return err
+	}
+	progress(imageupload.Progress{Phase: imageupload.PhaseSucceeded})
+	return nil
+}
+
+// imageNotInUse returns a Conflict error if a VM has a volume backed by the image name.
+func imageNotInUse(client kubecli.KubevirtClient, namespace, name string) error {
+	vmList, err := client.VirtualMachine(namespace).List(&k8smetav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	if consumers := imageConsumers(name, vmList.Items); len(consumers) > 0 {
+		return newAPIError(http.StatusConflict, ErrCodeConflict, "%s is used by the VMs %s", name, strings.Join(consumers, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	imageupload "virt-webui/controllers/imageUpload"
+	"virt-webui/models"
+
+	k8sv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	k8smetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"kubevirt.io/client-go/kubecli"
+	cdiv1 "kubevirt.io/containerized-data-importer/pkg/apis/core/v1alpha1"
+)
+
+const (
+	ImportSourceHTTP     = "http"
+	ImportSourceRegistry = "registry"
+
+	// Keys CDI reads the basic auth credentials of an import source from
+	importSecretUsernameKey = "accessKeyId"
+	importSecretPasswordKey = "secretKey"
+)
+
+// @Title Import Image
+// @Description Import a new image from an HTTP(S) URL or a container registry. The import runs in the cluster, poll the image import status for its progress.
+// @Param	body	body	controllers.JsonRequestImportImage	true	"The image source"
+// @Success 202 {object} controllers.JsonResponseImportImageSuccess
+// @Failure 400 Bad import request.
+// @Failure 500 Failed to import image.
+// @router /import [post]
+func (i *ImageController) Import() {
+	var jsonReq JsonRequestImportImage
+	json.Unmarshal(i.Ctx.Input.RequestBody, &jsonReq)
+	name := jsonReq.Name
+
+	dv, err := newImportDataVolume(&jsonReq)
+	if err != nil {
+		i.SetError("Bad import request.", badRequest(err))
+		i.ServeJSON()
+		return
+	}
+
+	namespace, virtClient, err := i.GetVirtClient()
+	if err != nil {
+		i.ResponseNotAvaliable(err)
+		return
+	}
+
+	if jsonReq.Username != "" {
+		secret, err := createImportSecret(*virtClient, *namespace, name, jsonReq.Username, jsonReq.Password)
+		if err != nil {
+			i.SetError("Failed to import "+name+".", err)
+			i.ServeJSON()
+			return
+		}
+		setImportSecretRef(dv, secret.Name)
+	}
+
+	dv, err = (*virtClient).CdiClient().CdiV1alpha1().DataVolumes(*namespace).Create(dv)
+	if err == nil {
+		if jsonReq.Username != "" {
+			ownImportSecret(*virtClient, dv)
+		}
+		i.Ctx.Output.SetStatus(202)
+		i.Data["json"] = JsonResponseImportImageSuccess{202, name + " import started.", newImageImport(dv)}
+	} else {
+		if jsonReq.Username != "" {
+			(*virtClient).CoreV1().Secrets(*namespace).Delete(importSecretName(name), &k8smetav1.DeleteOptions{})
+		}
+		i.SetError("Failed to import "+name+".", err)
+	}
+	i.ServeJSON()
+}
+
+type JsonRequestImportImage struct {
+	Name string
+	Size string
+	// The storage settings below default to the image* settings of app.conf.
+	StorageClass string
+	// AccessMode is ReadWriteOnce (RWO), ReadWriteMany (RWX) or ReadOnlyMany (ROX)
+	AccessMode string
+	// VolumeMode is Filesystem or Block
+	VolumeMode string
+	// SourceType is "http" (the default) or "registry"
+	SourceType string
+	// URL of the image, e.g. https://mirror/disk.qcow2 or docker://registry/image:tag
+	URL string
+	// CertConfigMap names a ConfigMap holding the CA bundle of the source
+	CertConfigMap string
+	// SecretRef names an existing Secret holding the source credentials
+	SecretRef string
+	// Username and Password are stored in a new Secret owned by the image
+	Username string
+	Password string
+}
+
+type JsonResponseImportImageSuccess struct {
+	StatusCode int
+	Message    string
+	Import     models.ImageImport
+}
+
+// @Title Get Image Import
+// @Description Get the phase and progress of an image import.
+// @Param	ImageName	path	string	true	"The imported image"
+// @Success 200 {object} controllers.JsonResponseImportImageSuccess
+// @Failure 500 Failed to get image import.
+// @router /import/:ImageName [get]
+func (i *ImageController) GetImport() {
+	namespace, virtClient, err := i.GetVirtClient()
+	if err != nil {
+		i.ResponseNotAvaliable(err)
+		return
+	}
+
+	imgName := i.Ctx.Input.Param(":ImageName")
+	dv, err := (*virtClient).CdiClient().CdiV1alpha1().DataVolumes(*namespace).Get(imgName, k8smetav1.GetOptions{})
+	if err == nil {
+		i.Data["json"] = JsonResponseImportImageSuccess{200, imgName + " import get success.", newImageImport(dv)}
+	} else {
+		i.SetError("Failed to get import of "+imgName+".", err)
+	}
+	i.ServeJSON()
+}
+
+// newImportDataVolume validates jsonReq and builds the DataVolume importing it.
+func newImportDataVolume(jsonReq *JsonRequestImportImage) (*cdiv1.DataVolume, error) {
+	if jsonReq.Name == "" || jsonReq.Size == "" || jsonReq.URL == "" {
+		return nil, fmt.Errorf("missing Name, Size or URL")
+	}
+	quantity, err := resource.ParseQuantity(jsonReq.Size)
+	if err != nil {
+		return nil, fmt.Errorf("validation failed for size=%s: %s", jsonReq.Size, err)
+	}
+	if err := storageSettings(&jsonReq.StorageClass, &jsonReq.AccessMode, &jsonReq.VolumeMode); err != nil {
+		return nil, err
+	}
+	if jsonReq.Username != "" && jsonReq.SecretRef != "" {
+		return nil, fmt.Errorf("set either Username or SecretRef, not both")
+	}
+
+	var source cdiv1.DataVolumeSource
+	switch jsonReq.SourceType {
+	case "", ImportSourceHTTP:
+		jsonReq.SourceType = ImportSourceHTTP
+		u, err := url.Parse(jsonReq.URL)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, fmt.Errorf("not an http or https URL: %s", jsonReq.URL)
+		}
+		source.HTTP = &cdiv1.DataVolumeSourceHTTP{
+			URL:           jsonReq.URL,
+			SecretRef:     jsonReq.SecretRef,
+			CertConfigMap: jsonReq.CertConfigMap,
+		}
+	case ImportSourceRegistry:
+		if !strings.Contains(jsonReq.URL, "://") {
+			jsonReq.URL = "docker://" + jsonReq.URL
+		}
+		source.Registry = &cdiv1.DataVolumeSourceRegistry{
+			URL:           jsonReq.URL,
+			SecretRef:     jsonReq.SecretRef,
+			CertConfigMap: jsonReq.CertConfigMap,
+		}
+	default:
+		return nil, fmt.Errorf("unknown SourceType %s", jsonReq.SourceType)
+	}
+
+	return &cdiv1.DataVolume{
+		ObjectMeta: k8smetav1.ObjectMeta{
+			Name: jsonReq.Name,
+		},
+		Spec: cdiv1.DataVolumeSpec{
+			Source: source,
+			PVC:    imageupload.NewPVCSpec(quantity, jsonReq.AccessMode, jsonReq.StorageClass, jsonReq.VolumeMode),
+		},
+	}, nil
+}
+
+func importSecretName(imgName string) string {
+	return imgName + "-import-credentials"
+}
+
+func createImportSecret(client kubecli.KubevirtClient, namespace, imgName, username, password string) (*k8sv1.Secret, error) {
+	secret := &k8sv1.Secret{
+		ObjectMeta: k8smetav1.ObjectMeta{
+			Name: importSecretName(imgName),
+		},
+		StringData: map[string]string{
+			importSecretUsernameKey: username,
+			importSecretPasswordKey: password,
+		},
+	}
+	return client.CoreV1().Secrets(namespace).Create(secret)
+}
+
+func setImportSecretRef(dv *cdiv1.DataVolume, secretName string) {
+	if dv.Spec.Source.HTTP != nil {
+		dv.Spec.Source.HTTP.SecretRef = secretName
+	}
+	if dv.Spec.Source.Registry != nil {
+		dv.Spec.Source.Registry.SecretRef = secretName
+	}
+}
+
+// ownImportSecret makes the credentials of dv go away together with it.
+func ownImportSecret(client kubecli.KubevirtClient, dv *cdiv1.DataVolume) {
+	ownSecret(client, dv.Namespace, importSecretName(dv.Name), k8smetav1.OwnerReference{
+		APIVersion: cdiv1.SchemeGroupVersion.String(),
+		Kind:       "DataVolume",
+		Name:       dv.Name,
+		UID:        dv.UID,
+	})
+}
+
+// ownSecret adds owner to the owners of a Secret created for it, so the Secret is garbage
+// collected with owner. A failure only leaves the Secret behind, so it is ignored.
+func ownSecret(client kubecli.KubevirtClient, namespace, secretName string, owner k8smetav1.OwnerReference) {
+	secrets := client.CoreV1().Secrets(namespace)
+	secret, err := secrets.Get(secretName, k8smetav1.GetOptions{})
+	if err != nil {
+		return
+	}
+	secret.OwnerReferences = append(secret.OwnerReferences, owner)
+	secrets.Update(secret)
+}
+
+func newImageImport(dv *cdiv1.DataVolume) models.ImageImport {
+	imp := models.ImageImport{
+		Name:       dv.Name,
+		Namespace:  dv.Namespace,
+		SourceType: imageSourceType(dv),
+		Phase:      string(dv.Status.Phase),
+		Progress:   string(dv.Status.Progress),
+	}
+	switch {
+	case dv.Spec.Source.HTTP != nil:
+		imp.URL = dv.Spec.Source.HTTP.URL
+	case dv.Spec.Source.Registry != nil:
+		imp.URL = dv.Spec.Source.Registry.URL
+	}
+	return imp
+}
@@ -0,0 +1,180 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+	imageupload "virt-webui/controllers/imageUpload"
+	"virt-webui/models"
+)
+
+// imageJobRetention is how long a finished upload job can still be polled.
+const imageJobRetention = 24 * time.Hour
+
+type imageJob struct {
+	job           models.ImageJob
+	transferStart time.Time
+	finished      bool
+	cancel        context.CancelFunc
+	err           error
+	// owner is the user who started the job, only they can see and cancel it
+	owner string
+
+	// transferred is closed once the upload no longer reads its data.
+	transferred chan struct{}
+}
+
+type imageJobStore struct {
+	sync.Mutex
+	jobs map[string]*imageJob
+}
+
+var imageJobs = &imageJobStore{jobs: map[string]*imageJob{}}
+
+// start runs upload in the background as a new job of owner. It returns the job ID and a
+// channel that is closed once the upload has stopped reading its data.
+func (s *imageJobStore) start(owner, name, source string, upload func(context.Context, imageupload.ProgressFunc) error) (string, <-chan struct{}) {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	id := hex.EncodeToString(buf)
+	now := time.Now()
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &imageJob{
+		job: models.ImageJob{
+			ID:         id,
+			Name:       name,
+			Source:     source,
+			Phase:      string(imageupload.PhaseCreatingDataVolume),
+			ETASeconds: -1,
+			StartTime:  now,
+			UpdateTime: now,
+		},
+		cancel:      cancel,
+		owner:       owner,
+		transferred: make(chan struct{}),
+	}
+
+	s.Lock()
+	for k, old := range s.jobs {
+		if old.finished && now.Sub(old.job.UpdateTime) > imageJobRetention {
+			delete(s.jobs, k)
+		}
+	}
+	s.jobs[id] = j
+	s.Unlock()
+
+	go func() {
+		defer cancel()
+		err := upload(ctx, func(p imageupload.Progress) {
+			s.update(j, p)
+		})
+		s.Lock()
+		defer s.Unlock()
+		if err != nil {
+			j.job.Phase = string(imageupload.PhaseFailed)
+			j.job.Message = err.Error()
+			j.err = err
+		}
+		j.job.UpdateTime = time.Now()
+		j.finish()
+	}()
+	return id, j.transferred
+}
+
+func (s *imageJobStore) update(j *imageJob, p imageupload.Progress) {
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	if p.Phase == imageupload.PhaseTransferring && j.transferStart.IsZero() {
+		j.transferStart = now
+	}
+	if p.Phase != imageupload.PhaseTransferring && !j.transferStart.IsZero() && p.Phase != imageupload.PhaseFailed {
+		// The data has been sent in full, so an unknown size is known now.
+		if j.job.TotalBytes <= 0 {
+			j.job.TotalBytes = j.job.BytesSent
+		}
+		j.closeTransferred()
+	}
+	j.job.Phase = string(p.Phase)
+	if p.BytesSent > j.job.BytesSent {
+		j.job.BytesSent = p.BytesSent
+	}
+	if p.TotalBytes > 0 {
+		j.job.TotalBytes = p.TotalBytes
+	}
+	j.job.UpdateTime = now
+}
+
+func (j *imageJob) finish() {
+	j.finished = true
+	j.closeTransferred()
+}
+
+func (j *imageJob) closeTransferred() {
+	select {
+	case <-j.transferred:
+	default:
+		close(j.transferred)
+	}
+}
+
+// lookup returns the job id of owner. The caller must hold the lock.
+func (s *imageJobStore) lookup(id, owner string) (*imageJob, bool) {
+	j, ok := s.jobs[id]
+	if !ok || j.owner != owner {
+		return nil, false
+	}
+	return j, true
+}
+
+// cancel aborts a running job of owner. It returns false if owner has no such job.
+func (s *imageJobStore) cancel(id, owner string) bool {
+	s.Lock()
+	defer s.Unlock()
+	j, ok := s.lookup(id, owner)
+	if ok {
+		j.cancel()
+	}
+	return ok
+}
+
+// failure returns the error the job failed with, nil if it did not fail.
+func (s *imageJobStore) failure(id string) error {
+	s.Lock()
+	defer s.Unlock()
+	if j, ok := s.jobs[id]; ok {
+		return j.err
+	}
+	return nil
+}
+
+// get returns a snapshot of the job of owner with its throughput and ETA filled in.
+func (s *imageJobStore) get(id, owner string) (models.ImageJob, bool) {
+	s.Lock()
+	defer s.Unlock()
+	j, ok := s.lookup(id, owner)
+	if !ok {
+		return models.ImageJob{}, false
+	}
+	job := j.job
+	if !j.transferStart.IsZero() {
+		end := time.Now()
+		if job.Phase != string(imageupload.PhaseTransferring) {
+			end = job.UpdateTime
+		}
+		if elapsed := end.Sub(j.transferStart).Seconds(); elapsed > 0 {
+			job.Throughput = float64(job.BytesSent) / elapsed
+		}
+	}
+	switch {
+	case job.Phase != string(imageupload.PhaseTransferring):
+		if j.finished || !j.transferStart.IsZero() {
+			job.ETASeconds = 0
+		}
+	case job.TotalBytes > 0 && job.Throughput > 0:
+		job.ETASeconds = float64(job.TotalBytes-job.BytesSent) / job.Throughput
+	}
+	return job, true
+}
@@ -1,8 +1,10 @@
 package imageupload
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -12,8 +14,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/spf13/pflag"
-	pb "gopkg.in/cheggaaa/pb.v1"
 	v1 "k8s.io/api/core/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
@@ -21,7 +21,6 @@ import (
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	cdiClientset "kubevirt.io/client-go/generated/containerized-data-importer/clientset/versioned"
-	"kubevirt.io/client-go/kubecli"
 	cdiv1 "kubevirt.io/containerized-data-importer/pkg/apis/core/v1alpha1"
 	uploadcdiv1 "kubevirt.io/containerized-data-importer/pkg/apis/upload/v1alpha1"
 )
@@ -49,80 +48,164 @@ const (
 	configName = "config"
 )
 
-var (
-	insecure       bool
-	uploadProxyURL string
-	name           string
-	size           string
-	imagePath      string
-	accessMode     string
+// Phase is the stage an upload is in.
+type Phase string
 
-	uploadPodWaitSecs uint
+const (
+	// PhaseCreatingDataVolume means the upload DataVolume is being created
+	PhaseCreatingDataVolume Phase = "CreatingDataVolume"
+	// PhaseWaitingForUploadPod means the upload server pod is not ready to receive data yet
+	PhaseWaitingForUploadPod Phase = "WaitingForUploadPod"
+	// PhaseTransferring means the data is being sent to the upload proxy
+	PhaseTransferring Phase = "Transferring"
+	// PhaseProcessing means the data has been sent and CDI is processing it
+	PhaseProcessing Phase = "Processing"
+	// PhaseSucceeded means the image is ready
+	PhaseSucceeded Phase = "Succeeded"
+	// PhaseFailed means the upload failed
+	PhaseFailed Phase = "Failed"
 )
 
-type HTTPClientCreator func(bool) *http.Client
-
-var httpClientCreatorFunc HTTPClientCreator
+// Progress reports how far an upload has come.
+type Progress struct {
+	Phase      Phase
+	BytesSent  int64
+	TotalBytes int64 // <= 0 if unknown
+}
 
-type processingCompleteFunc func(kubernetes.Interface, string, string, time.Duration, time.Duration) error
+// ProgressFunc is called whenever an upload changes phase and as its data is sent.
+type ProgressFunc func(Progress)
 
-// UploadProcessingCompleteFunc the function called while determining if post transfer processing is complete.
-var UploadProcessingCompleteFunc processingCompleteFunc = waitUploadProcessingComplete
+type HTTPClientCreator func(bool) *http.Client
 
-// SetHTTPClientCreator allows overriding the default http client
-// useful for unit tests
-func SetHTTPClientCreator(f HTTPClientCreator) {
-	httpClientCreatorFunc = f
+type processingCompleteFunc func(context.Context, kubernetes.Interface, string, string, time.Duration, time.Duration) error
+
+// Options are the settings of a single upload.
+type Options struct {
+	// Name of the DataVolume to create
+	Name string
+	// Size of the DataVolume, e.g. 10Gi
+	Size string
+	// AccessMode of the DataVolume, e.g. ReadWriteOnce
+	AccessMode string
+	// StorageClass of the DataVolume, the cluster default if empty
+	StorageClass string
+	// VolumeMode of the DataVolume, Filesystem or Block, the cluster default if empty
+	VolumeMode string
+	// UploadProxyURL is looked up in the CDI config when empty
+	UploadProxyURL string
+	// Insecure skips verifying the upload proxy certificate
+	Insecure bool
+	// UploadPodWaitSecs is how long to wait for the upload server pod to become ready
+	UploadPodWaitSecs uint
+	// Source describes where the data comes from and is only used in log messages
+	Source string
+	// Progress, if not nil, is kept informed of the upload phase and the number of bytes sent
+	Progress ProgressFunc
 }
 
-// SetDefaultHTTPClientCreator sets the http client creator back to default
-func SetDefaultHTTPClientCreator() {
-	httpClientCreatorFunc = getHTTPClient
+// Uploader uploads images into DataVolumes of one namespace.
+// It holds no per-upload state, so it can run any number of uploads at once.
+type Uploader struct {
+	client    kubernetes.Interface
+	cdiClient cdiClientset.Interface
+	namespace string
+
+	// HTTPClientCreator creates the client used to talk to the upload proxy
+	HTTPClientCreator HTTPClientCreator
+	// ProcessingComplete is called while determining if post transfer processing is complete
+	ProcessingComplete processingCompleteFunc
+
+	readyWaitInterval      time.Duration
+	processingWaitInterval time.Duration
+	processingWaitTotal    time.Duration
 }
 
-func init() {
-	SetDefaultHTTPClientCreator()
+// NewUploader returns an Uploader creating its DataVolumes in namespace.
+func NewUploader(client kubernetes.Interface, cdiClient cdiClientset.Interface, namespace string) *Uploader {
+	return &Uploader{
+		client:                 client,
+		cdiClient:              cdiClient,
+		namespace:              namespace,
+		HTTPClientCreator:      getHTTPClient,
+		ProcessingComplete:     waitUploadProcessingComplete,
+		readyWaitInterval:      uploadReadyWaitInterval,
+		processingWaitInterval: processingWaitInterval,
+		processingWaitTotal:    processingWaitTotal,
+	}
 }
 
-func UploadImage(insecure0 bool, uploadProxyURL0, name0, size0, imagePath0, accessMode0 string, uploadPodWaitSecs0 uint) error {
-	insecure = insecure0
-	uploadProxyURL, name, size, imagePath, accessMode = uploadProxyURL0, name0, size0, imagePath0, accessMode0
-	uploadPodWaitSecs = uploadPodWaitSecs0
-	file, err := os.Open(imagePath)
+// UploadFile uploads the file at filePath on the local disk into a new DataVolume.
+func (u *Uploader) UploadFile(ctx context.Context, opts Options, filePath string) error {
+	file, err := os.Open(filePath)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	clientConfig := kubecli.DefaultClientConfig(&pflag.FlagSet{})
-	namespace, _, err := clientConfig.Namespace()
+	fi, err := file.Stat()
 	if err != nil {
 		return err
 	}
 
-	virtClient, err := kubecli.GetKubevirtClientFromClientConfig(clientConfig)
-	if err != nil {
-		return fmt.Errorf("cannot obtain KubeVirt client: %v", err)
+	if opts.Source == "" {
+		opts.Source = filePath
 	}
+	return u.Upload(ctx, opts, file, fi.Size())
+}
 
-	err = getAndValidateUploadPVC(virtClient, namespace, name)
+// Upload uploads the content read from reader into a new DataVolume.
+// The data is streamed to the upload proxy as it is read, so it is never staged in memory or on disk.
+// length is the number of bytes reader will yield, or a value <= 0 if it is unknown.
+// Cancelling ctx aborts the upload.
+func (u *Uploader) Upload(ctx context.Context, opts Options, reader io.Reader, length int64) (err error) {
+	progress := opts.Progress
+	if progress == nil {
+		progress = func(Progress) {}
+	}
+	defer func() {
+		if err != nil {
+			progress(Progress{Phase: PhaseFailed, TotalBytes: length})
+		}
+	}()
+	progress(Progress{Phase: PhaseCreatingDataVolume, TotalBytes: length})
+
+	namespace, name := u.namespace, opts.Name
+
+	err = getAndValidateUploadPVC(u.client, namespace, name)
 	if err != nil {
 		return err
 	}
-	dv, err := createUploadDataVolume(virtClient, namespace, name, size, accessMode)
+	dv, err := createUploadDataVolume(u.cdiClient, namespace, opts)
 	if err != nil {
 		return err
 	}
 
 	fmt.Printf("DataVolume %s/%s created\n", dv.Namespace, dv.Name)
 
-	err = waitUploadServerReady(virtClient, namespace, name, uploadReadyWaitInterval, time.Duration(uploadPodWaitSecs)*time.Second)
+	// Until the data is sent in full, a failed upload leaves a half-written DataVolume that would
+	// make every retry fail, so it is deleted. Once CDI processes the data it is kept.
+	processing := false
+	defer func() {
+		if err == nil {
+			return
+		}
+		if processing {
+			err = fmt.Errorf("%v, the DataVolume %s/%s is kept", err, namespace, name)
+		} else if delErr := u.cdiClient.CdiV1alpha1().DataVolumes(namespace).Delete(name, &metav1.DeleteOptions{}); delErr != nil {
+			err = fmt.Errorf("%v, and the DataVolume %s/%s could not be deleted: %v", err, namespace, name, delErr)
+		}
+	}()
+
+	progress(Progress{Phase: PhaseWaitingForUploadPod, TotalBytes: length})
+	err = waitUploadServerReady(ctx, u.client, namespace, name, u.readyWaitInterval, time.Duration(opts.UploadPodWaitSecs)*time.Second)
 	if err != nil {
 		return err
 	}
 
+	uploadProxyURL := opts.UploadProxyURL
 	if uploadProxyURL == "" {
-		uploadProxyURL, err = getUploadProxyURL(virtClient.CdiClient())
+		uploadProxyURL, err = getUploadProxyURL(u.cdiClient)
 		if err != nil {
 			return err
 		}
@@ -131,33 +214,38 @@ func UploadImage(insecure0 bool, uploadProxyURL0, name0, size0, imagePath0, acce
 		}
 	}
 
-	u, err := url.Parse(uploadProxyURL)
+	proxyURL, err := url.Parse(uploadProxyURL)
 	if err != nil {
 		return err
 	}
 
-	if u.Scheme == "" {
+	if proxyURL.Scheme == "" {
 		uploadProxyURL = fmt.Sprintf("https://%s", uploadProxyURL)
 	}
 
-	fmt.Printf("Uploading data to %s\n", uploadProxyURL)
+	fmt.Printf("Uploading %s data to %s\n", name, uploadProxyURL)
 
-	token, err := getUploadToken(virtClient.CdiClient(), namespace, name)
+	token, err := getUploadToken(u.cdiClient, namespace, name)
 	if err != nil {
 		return err
 	}
 
-	err = uploadData(uploadProxyURL, token, file, insecure)
+	client := u.HTTPClientCreator(opts.Insecure)
+	err = uploadData(ctx, client, uploadProxyURL, token, reader, length, progress)
 	if err != nil {
 		return err
 	}
 
-	fmt.Println("Uploading data completed successfully, waiting for processing to complete, you can hit ctrl-c without interrupting the progress")
-	err = UploadProcessingCompleteFunc(virtClient, namespace, name, processingWaitInterval, processingWaitTotal)
+	processing = true
+	progress(Progress{Phase: PhaseProcessing, BytesSent: length, TotalBytes: length})
+
+	fmt.Printf("Uploading %s data completed successfully, waiting for processing to complete\n", name)
+	err = u.ProcessingComplete(ctx, u.client, namespace, name, u.processingWaitInterval, u.processingWaitTotal)
 	if err != nil {
-		fmt.Printf("Timed out waiting for post upload processing to complete, please check upload pod status for progress\n")
+		fmt.Printf("Post upload processing of %s did not complete: %v\n", name, err)
 	} else {
-		fmt.Printf("Uploading %s completed successfully\n", imagePath)
+		fmt.Printf("Uploading %s completed successfully\n", opts.Source)
+		progress(Progress{Phase: PhaseSucceeded, BytesSent: length, TotalBytes: length})
 	}
 
 	return err
@@ -168,37 +256,29 @@ func getAndValidateUploadPVC(client kubernetes.Interface, namespace, name string
 	if err != nil {
 		return nil
 	}
-	return fmt.Errorf("PVC %s already exists.", name)
+	return k8serrors.NewAlreadyExists(v1.Resource("persistentvolumeclaims"), name)
 }
 
-func createUploadDataVolume(client kubecli.KubevirtClient, namespace, name, size, accessMode string) (*cdiv1.DataVolume, error) {
-	quantity, err := resource.ParseQuantity(size)
+func createUploadDataVolume(client cdiClientset.Interface, namespace string, opts Options) (*cdiv1.DataVolume, error) {
+	quantity, err := resource.ParseQuantity(opts.Size)
 	if err != nil {
-		return nil, fmt.Errorf("validation failed for size=%s: %s", size, err)
+		return nil, fmt.Errorf("validation failed for size=%s: %s", opts.Size, err)
 	}
 
 	dv := &cdiv1.DataVolume{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      name,
+			Name:      opts.Name,
 			Namespace: namespace,
 		},
 		Spec: cdiv1.DataVolumeSpec{
 			Source: cdiv1.DataVolumeSource{
 				Upload: &cdiv1.DataVolumeSourceUpload{},
 			},
-			PVC: &v1.PersistentVolumeClaimSpec{
-				Resources: v1.ResourceRequirements{
-					Requests: v1.ResourceList{
-						v1.ResourceStorage: quantity,
-					},
-				},
-			},
+			PVC: NewPVCSpec(quantity, opts.AccessMode, opts.StorageClass, opts.VolumeMode),
 		},
 	}
 
-	dv.Spec.PVC.AccessModes = []v1.PersistentVolumeAccessMode{v1.PersistentVolumeAccessMode(accessMode)}
-
-	dv, err = client.CdiClient().CdiV1alpha1().DataVolumes(namespace).Create(dv)
+	dv, err = client.CdiV1alpha1().DataVolumes(namespace).Create(dv)
 	if err != nil {
 		return nil, err
 	}
@@ -206,10 +286,42 @@ func createUploadDataVolume(client kubecli.KubevirtClient, namespace, name, size
 	return dv, nil
 }
 
-func waitUploadServerReady(client kubernetes.Interface, namespace, name string, interval, timeout time.Duration) error {
+// NewPVCSpec returns the spec of a PVC of size with accessMode. The cluster defaults are used
+// for storageClass and volumeMode if they are empty.
+func NewPVCSpec(size resource.Quantity, accessMode, storageClass, volumeMode string) *v1.PersistentVolumeClaimSpec {
+	spec := &v1.PersistentVolumeClaimSpec{
+		AccessModes: []v1.PersistentVolumeAccessMode{v1.PersistentVolumeAccessMode(accessMode)},
+		Resources: v1.ResourceRequirements{
+			Requests: v1.ResourceList{
+				v1.ResourceStorage: size,
+			},
+		},
+	}
+	if storageClass != "" {
+		spec.StorageClassName = &storageClass
+	}
+	if volumeMode != "" {
+		mode := v1.PersistentVolumeMode(volumeMode)
+		spec.VolumeMode = &mode
+	}
+	return spec
+}
+
+// poll is wait.PollImmediate that also gives up when ctx is done.
+func poll(ctx context.Context, interval, timeout time.Duration, condition wait.ConditionFunc) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err := wait.PollImmediateUntil(interval, condition, ctx.Done())
+	if err == wait.ErrWaitTimeout && ctx.Err() == context.Canceled {
+		return ctx.Err()
+	}
+	return err
+}
+
+func waitUploadServerReady(ctx context.Context, client kubernetes.Interface, namespace, name string, interval, timeout time.Duration) error {
 	loggedStatus := false
 
-	err := wait.PollImmediate(interval, timeout, func() (bool, error) {
+	err := poll(ctx, interval, timeout, func() (bool, error) {
 		pvc, err := client.CoreV1().PersistentVolumeClaims(namespace).Get(name, metav1.GetOptions{})
 		if err != nil {
 			// DataVolume controller may not have created the PVC yet
@@ -229,7 +341,7 @@ func waitUploadServerReady(client kubernetes.Interface, namespace, name string,
 		}
 
 		if done && loggedStatus {
-			fmt.Printf("Pod now ready\n")
+			fmt.Printf("Pod of PVC %s now ready\n", name)
 		}
 
 		return done, nil
@@ -270,35 +382,45 @@ func getUploadToken(client cdiClientset.Interface, namespace, name string) (stri
 	return response.Status.Token, nil
 }
 
-func uploadData(uploadProxyURL, token string, file *os.File, insecure bool) error {
-	url, err := ConstructUploadProxyPathAsync(uploadProxyURL, token, insecure)
-	if err != nil {
-		return err
-	}
+// progressReader reports the number of bytes read through it.
+type progressReader struct {
+	io.Reader
+	progress Progress
+	report   ProgressFunc
+}
 
-	fi, err := file.Stat()
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.progress.BytesSent += int64(n)
+	r.report(r.progress)
+	return n, err
+}
+
+func uploadData(ctx context.Context, client *http.Client, uploadProxyURL, token string, data io.Reader, length int64, progress ProgressFunc) error {
+	url, err := ConstructUploadProxyPathAsync(client, uploadProxyURL, token)
 	if err != nil {
 		return err
 	}
 
-	bar := pb.New64(fi.Size()).SetUnits(pb.U_BYTES)
-	reader := bar.NewProxyReader(file)
+	if length < 0 {
+		length = 0
+	}
+	reader := &progressReader{
+		Reader:   data,
+		progress: Progress{Phase: PhaseTransferring, TotalBytes: length},
+		report:   progress,
+	}
+	progress(reader.progress)
 
-	client := httpClientCreatorFunc(insecure)
 	req, _ := http.NewRequest("POST", url, reader)
+	req = req.WithContext(ctx)
 
 	req.Header.Add("Authorization", "Bearer "+token)
 	req.Header.Add("Content-Type", "application/octet-stream")
-	req.ContentLength = fi.Size()
-
-	fmt.Println()
-	bar.Start()
+	// A zero ContentLength with a non-nil body makes the transfer chunked.
+	req.ContentLength = length
 
 	resp, err := client.Do(req)
-
-	bar.Finish()
-	fmt.Println()
-
 	if err != nil {
 		return err
 	}
@@ -315,7 +437,7 @@ func uploadData(uploadProxyURL, token string, file *os.File, insecure bool) erro
 }
 
 //ConstructUploadProxyPathAsync - receives uploadproxy adress and concatenates to it URI
-func ConstructUploadProxyPathAsync(uploadProxyURL, token string, insecure bool) (string, error) {
+func ConstructUploadProxyPathAsync(client *http.Client, uploadProxyURL, token string) (string, error) {
 	u, err := url.Parse(uploadProxyURL)
 
 	if err != nil {
@@ -327,10 +449,12 @@ func ConstructUploadProxyPathAsync(uploadProxyURL, token string, insecure bool)
 	}
 
 	// Attempt to discover async URL
-	client := httpClientCreatorFunc(insecure)
 	req, _ := http.NewRequest("HEAD", u.String(), nil)
 	req.Header.Add("Authorization", "Bearer "+token)
 	resp, err := client.Do(req)
+	if err == nil {
+		resp.Body.Close()
+	}
 	if err != nil || resp.StatusCode != http.StatusOK {
 		// Async not available, use regular upload url.
 		return ConstructUploadProxyPath(uploadProxyURL)
@@ -353,8 +477,8 @@ func ConstructUploadProxyPath(uploadProxyURL string) (string, error) {
 	return u.String(), nil
 }
 
-func waitUploadProcessingComplete(client kubernetes.Interface, namespace, name string, interval, timeout time.Duration) error {
-	err := wait.PollImmediate(interval, timeout, func() (bool, error) {
+func waitUploadProcessingComplete(ctx context.Context, client kubernetes.Interface, namespace, name string, interval, timeout time.Duration) error {
+	err := poll(ctx, interval, timeout, func() (bool, error) {
 		pvc, err := client.CoreV1().PersistentVolumeClaims(namespace).Get(name, metav1.GetOptions{})
 		if err != nil {
 			return false, err
@@ -364,7 +488,7 @@ func waitUploadProcessingComplete(client kubernetes.Interface, namespace, name s
 		podPhase := pvc.Annotations[PodPhaseAnnotation]
 
 		if podPhase == string(v1.PodSucceeded) {
-			fmt.Printf("Processing completed successfully\n")
+			fmt.Printf("Processing of %s completed successfully\n", name)
 		}
 
 		return podPhase == string(v1.PodSucceeded), nil
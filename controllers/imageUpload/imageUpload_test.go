@@ -0,0 +1,225 @@
+package imageupload
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	cdifake "kubevirt.io/client-go/generated/containerized-data-importer/clientset/versioned/fake"
+	cdiv1 "kubevirt.io/containerized-data-importer/pkg/apis/core/v1alpha1"
+	uploadcdiv1 "kubevirt.io/containerized-data-importer/pkg/apis/upload/v1alpha1"
+)
+
+const testNamespace = "default"
+
+// fakeUploadProxy records the data it receives per upload token.
+type fakeUploadProxy struct {
+	sync.Mutex
+	received map[string][]byte
+}
+
+func (p *fakeUploadProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if r.URL.Path != UploadProxyURIAsync {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	p.Lock()
+	p.received[token] = data
+	p.Unlock()
+}
+
+// newTestUploader returns an Uploader backed by fake clientsets. Creating a DataVolume
+// immediately creates its PVC with a ready upload pod when podReady is true.
+func newTestUploader(podReady bool) *Uploader {
+	client := k8sfake.NewSimpleClientset()
+	cdiClient := cdifake.NewSimpleClientset()
+
+	cdiClient.PrependReactor("create", "datavolumes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		dv := action.(k8stesting.CreateAction).GetObject().(*cdiv1.DataVolume)
+		pvc := &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      dv.Name,
+				Namespace: dv.Namespace,
+				Annotations: map[string]string{
+					PodReadyAnnotation: fmt.Sprint(podReady),
+					PodPhaseAnnotation: string(v1.PodSucceeded),
+				},
+			},
+		}
+		_, err := client.CoreV1().PersistentVolumeClaims(dv.Namespace).Create(pvc)
+		return false, nil, err
+	})
+	cdiClient.PrependReactor("create", "uploadtokenrequests", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		request := action.(k8stesting.CreateAction).GetObject().(*uploadcdiv1.UploadTokenRequest).DeepCopy()
+		request.Status.Token = "token-" + request.Spec.PvcName
+		return true, request, nil
+	})
+
+	u := NewUploader(client, cdiClient, testNamespace)
+	u.readyWaitInterval = 10 * time.Millisecond
+	u.processingWaitInterval = 10 * time.Millisecond
+	return u
+}
+
+func TestParallelUploads(t *testing.T) {
+	proxy := &fakeUploadProxy{received: map[string][]byte{}}
+	server := httptest.NewTLSServer(proxy)
+	defer server.Close()
+
+	u := newTestUploader(true)
+
+	const uploads = 8
+	images := make([][]byte, uploads)
+	phases := make([][]Phase, uploads)
+	var wg sync.WaitGroup
+	errs := make(chan error, uploads)
+	for n := 0; n < uploads; n++ {
+		images[n] = bytes.Repeat([]byte{byte(n)}, 64*1024*(n+1))
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			opts := Options{
+				Name:              fmt.Sprintf("image-%d", n),
+				Size:              "1Gi",
+				AccessMode:        "ReadWriteOnce",
+				UploadProxyURL:    server.URL,
+				Insecure:          true,
+				UploadPodWaitSecs: 5,
+				Progress: func(p Progress) {
+					if l := len(phases[n]); l == 0 || phases[n][l-1] != p.Phase {
+						phases[n] = append(phases[n], p.Phase)
+					}
+				},
+			}
+			if err := u.Upload(context.Background(), opts, bytes.NewReader(images[n]), int64(len(images[n]))); err != nil {
+				errs <- fmt.Errorf("upload %d: %v", n, err)
+			}
+		}(n)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	expectedPhases := []Phase{PhaseCreatingDataVolume, PhaseWaitingForUploadPod, PhaseTransferring, PhaseProcessing, PhaseSucceeded}
+	for n := 0; n < uploads; n++ {
+		received := proxy.received[fmt.Sprintf("token-image-%d", n)]
+		if !bytes.Equal(received, images[n]) {
+			t.Errorf("upload %d: proxy received %d bytes, expected %d", n, len(received), len(images[n]))
+		}
+		if fmt.Sprint(phases[n]) != fmt.Sprint(expectedPhases) {
+			t.Errorf("upload %d: phases %v, expected %v", n, phases[n], expectedPhases)
+		}
+	}
+}
+
+func TestUploadExistingPVC(t *testing.T) {
+	u := newTestUploader(true)
+	u.client.CoreV1().PersistentVolumeClaims(testNamespace).Create(&v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "taken", Namespace: testNamespace},
+	})
+
+	err := u.Upload(context.Background(), Options{Name: "taken", Size: "1Gi"}, bytes.NewReader(nil), 0)
+	if err == nil {
+		t.Fatal("expected an error for an existing PVC")
+	}
+}
+
+func TestUploadCancel(t *testing.T) {
+	u := newTestUploader(false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var failed bool
+	opts := Options{
+		Name:              "cancelled",
+		Size:              "1Gi",
+		UploadPodWaitSecs: 60,
+		Progress: func(p Progress) {
+			if p.Phase == PhaseWaitingForUploadPod {
+				cancel()
+			}
+			failed = p.Phase == PhaseFailed
+		},
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- u.Upload(ctx, opts, bytes.NewReader(nil), 0)
+	}()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("expected %v, got %v", context.Canceled, err)
+		}
+		if !failed {
+			t.Error("expected the upload to report the failed phase")
+		}
+		if _, err := u.cdiClient.CdiV1alpha1().DataVolumes(testNamespace).Get("cancelled", metav1.GetOptions{}); !k8serrors.IsNotFound(err) {
+			t.Errorf("expected the DataVolume of the cancelled upload to be deleted, got %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("upload was not cancelled")
+	}
+}
+
+func TestUploadProcessingFailure(t *testing.T) {
+	proxy := &fakeUploadProxy{received: map[string][]byte{}}
+	server := httptest.NewTLSServer(proxy)
+	defer server.Close()
+
+	u := newTestUploader(true)
+	u.ProcessingComplete = func(context.Context, kubernetes.Interface, string, string, time.Duration, time.Duration) error {
+		return fmt.Errorf("DataVolume failed")
+	}
+	opts := Options{Name: "broken", Size: "1Gi", UploadProxyURL: server.URL, Insecure: true, UploadPodWaitSecs: 5}
+	err := u.Upload(context.Background(), opts, bytes.NewReader([]byte("data")), 4)
+	if err == nil || !strings.Contains(err.Error(), "DataVolume failed") || !strings.Contains(err.Error(), "is kept") {
+		t.Errorf("expected the processing error and the kept DataVolume, got %v", err)
+	}
+	if _, err := u.cdiClient.CdiV1alpha1().DataVolumes(testNamespace).Get("broken", metav1.GetOptions{}); err != nil {
+		t.Errorf("expected the DataVolume being processed to be kept, got %v", err)
+	}
+}
+
+func TestConstructUploadProxyPath(t *testing.T) {
+	for _, c := range []struct{ in, out string }{
+		{"https://proxy:8443", "https://proxy:8443" + UploadProxyURI},
+		{"https://proxy:8443/base", "https://proxy:8443/base" + UploadProxyURI},
+		{"https://proxy:8443" + UploadProxyURI, "https://proxy:8443" + UploadProxyURI},
+	} {
+		out, err := ConstructUploadProxyPath(c.in)
+		if err != nil {
+			t.Errorf("%s: %v", c.in, err)
+		} else if out != c.out {
+			t.Errorf("%s: got %s, expected %s", c.in, out, c.out)
+		}
+	}
+}
@@ -1,123 +1,260 @@
 package controllers
 
 import (
+	"context"
 	"encoding/json"
-	"flag"
-	"log"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"virt-webui/controllers/auth"
 	imageupload "virt-webui/controllers/imageUpload"
 	"virt-webui/models"
 
 	"github.com/astaxie/beego"
-	"github.com/spf13/pflag"
+	beecontext "github.com/astaxie/beego/context"
+	k8sv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	k8smetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
-	"k8s.io/client-go/dynamic"
-	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/client-go/util/homedir"
+	v1 "kubevirt.io/client-go/api/v1"
 	"kubevirt.io/client-go/kubecli"
+	cdiv1 "kubevirt.io/containerized-data-importer/pkg/apis/core/v1alpha1"
 )
 
-func (i *ImageController) ResponseNotAvaliable() {
-	i.Data["json"] = JsonResponseBasic{500, "Not avaliable."}
-	i.ServeJSON()
-	return
-}
-
-func (v *VMController) ResponseNotAvaliable() {
-	v.Data["json"] = JsonResponseBasic{500, "Not avaliable."}
-	v.ServeJSON()
-	return
+// GetVirtClient returns the shared KubeVirt client of the default cluster and the default namespace of its config.
+func GetVirtClient() (*string, *kubecli.KubevirtClient, error) {
+	return getClusterClient("", nil)
 }
 
-func GetVirtClient() (bool, *string, *kubecli.KubevirtClient) {
-	// kubecli.DefaultClientConfig() prepares config using kubeconfig.
-	// typically, you need to set env variable, KUBECONFIG=<path-to-kubeconfig>/.kubeconfig
-	clientConfig := kubecli.DefaultClientConfig(&pflag.FlagSet{})
-
-	// retrive default namespace.
-	namespace, _, err := clientConfig.Namespace()
-	if err != nil {
-		log.Fatalf("error in KubeVirt namespace : %v\n", err)
-		return false, nil, nil
-	}
-
-	// get the kubevirt client, using which kubevirt resources can be managed.
-	virtClient, err := kubecli.GetKubevirtClientFromClientConfig(clientConfig)
+// getClusterClient is GetVirtClient for the cluster name, the default cluster if name is empty.
+// If identity is set, the client impersonates it.
+func getClusterClient(name string, identity *auth.Identity) (*string, *kubecli.KubevirtClient, error) {
+	cluster, err := clusters.get(name)
 	if err != nil {
-		log.Fatalf("cannot obtain KubeVirt client: %v\n", err)
-		return false, nil, nil
+		return nil, nil, err
 	}
-	return true, &namespace, &virtClient
-}
-
-var kubeconfig *string
-
-func GetDynamicClient() (bool, *string, *dynamic.Interface) {
-	if kubeconfig == nil {
-		if home := homedir.HomeDir(); home != "" {
-			kubeconfig = flag.String("kubeconfig", filepath.Join(home, ".kube", "config"), "(optional) absolute path to the kubeconfig file")
-		} else {
-			kubeconfig = flag.String("kubeconfig", "", "absolute path to the kubeconfig file")
-		}
-		flag.Parse()
-	}
-
-	namespace := "default"
-
-	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
-	if err != nil {
-		log.Fatalf("error in dynamic config : %v\n", err)
-		return false, nil, nil
+	var namespace string
+	var virtClient kubecli.KubevirtClient
+	if identity != nil {
+		namespace, virtClient, err = cluster.getAs(identity)
+	} else {
+		namespace, virtClient, _, err = cluster.get()
 	}
-	client, err := dynamic.NewForConfig(config)
 	if err != nil {
-		log.Fatalf("error in dynamic client : %v\n", err)
-		return false, nil, nil
+		return nil, nil, fmt.Errorf("cannot obtain KubeVirt client: %v", err)
 	}
-	return true, &namespace, &client
+	return &namespace, &virtClient, nil
 }
 
 // Operations about image
 type ImageController struct {
-	beego.Controller
+	baseController
 }
 
 type JsonResponseBasic struct {
 	StatusCode int
-	Message    string
+	// Code tells why a request failed, e.g. NotFound or AlreadyExists
+	Code    string `json:",omitempty"`
+	Message string
 }
 
 // @Title List Image
 // @Description List all images.
 // @Success 200 {object} controllers.JsonResponseListImageSuccess
+// @Failure 403 Forbidden by the RBAC rules of the cluster.
 // @Failure 500 Failed to list images.
 // @router / [get]
 func (i *ImageController) GetAll() {
-	ok, namespace, virtClient := GetVirtClient()
-	if !ok {
-		i.ResponseNotAvaliable()
+	namespace, virtClient, err := i.GetVirtClient()
+	if err != nil {
+		i.ResponseNotAvaliable(err)
 		return
 	}
-	imgList, err := (*virtClient).CdiClient().CdiV1alpha1().DataVolumes(*namespace).List(k8smetav1.ListOptions{})
+	// The clones of the VM snapshots are not images
+	imgList, err := (*virtClient).CdiClient().CdiV1alpha1().DataVolumes(*namespace).List(k8smetav1.ListOptions{LabelSelector: "!" + snapshotLabel})
 
 	if err != nil {
-		log.Fatalf("cannot obtain KubeVirt image list: %v\n", err)
-		i.Ctx.Output.SetStatus(500)
-		i.Data["json"] = JsonResponseBasic{500, "Failed to list images. " + err.Error()}
+		i.SetError("Failed to list images.", err)
 		i.ServeJSON()
 		return
 	}
 
+	// The details below are best effort, an image is still listed without them.
+	pvcs := map[string]*k8sv1.PersistentVolumeClaim{}
+	pvcList, err := (*virtClient).CoreV1().PersistentVolumeClaims(*namespace).List(k8smetav1.ListOptions{})
+	if err == nil {
+		for n := range pvcList.Items {
+			pvcs[pvcList.Items[n].Name] = &pvcList.Items[n]
+		}
+	}
+	var vms []v1.VirtualMachine
+	vmList, err := (*virtClient).VirtualMachine(*namespace).List(&k8smetav1.ListOptions{})
+	if err == nil {
+		vms = vmList.Items
+	}
+
 	var imgs []models.Image
-	for _, img := range imgList.Items {
-		imgs = append(imgs, models.Image{img.Name, img.Namespace})
+	for n := range imgList.Items {
+		img := &imgList.Items[n]
+		if isImage(img) {
+			imgs = append(imgs, newImage(img, pvcs[img.Name], vms))
+		}
 	}
 
 	i.Data["json"] = JsonResponseListImageSuccess{200, "Images list success.", imgs}
 	i.ServeJSON()
 }
 
+// @Title Get Image
+// @Description Get details of an exist image.
+// @Param	ImageName	path	string	true	"The image you want to get"
+// @Success 200 {object} controllers.JsonResponseGetImageSuccess
+// @Failure 500 Failed to get image.
+// @router /:ImageName [get]
+func (i *ImageController) Get() {
+	namespace, virtClient, err := i.GetVirtClient()
+	if err != nil {
+		i.ResponseNotAvaliable(err)
+		return
+	}
+
+	imgName := i.Ctx.Input.Param(":ImageName")
+	img, err := getImage(*virtClient, *namespace, imgName)
+	if err != nil {
+		i.SetError("Failed to get "+imgName+".", err)
+		i.ServeJSON()
+		return
+	}
+
+	// The PVC does not exist before CDI creates it.
+	pvc, err := (*virtClient).CoreV1().PersistentVolumeClaims(*namespace).Get(imgName, k8smetav1.GetOptions{})
+	if err != nil {
+		pvc = nil
+	}
+	var vms []v1.VirtualMachine
+	vmList, err := (*virtClient).VirtualMachine(*namespace).List(&k8smetav1.ListOptions{})
+	if err == nil {
+		vms = vmList.Items
+	}
+
+	i.Data["json"] = JsonResponseGetImageSuccess{200, imgName + " get success.", newImage(img, pvc, vms)}
+	i.ServeJSON()
+}
+
+type JsonResponseGetImageSuccess struct {
+	StatusCode int
+	Message    string
+	Image      models.Image
+}
+
+// newImage describes the DataVolume dv. pvc is the PVC of dv, or nil if it does not exist yet,
+// and vms are searched for the ones using dv.
+func newImage(dv *cdiv1.DataVolume, pvc *k8sv1.PersistentVolumeClaim, vms []v1.VirtualMachine) models.Image {
+	img := models.Image{
+		Name:         dv.Name,
+		Namespace:    dv.Namespace,
+		Phase:        string(dv.Status.Phase),
+		Progress:     string(dv.Status.Progress),
+		CreationTime: dv.CreationTimestamp.Time,
+		SourceType:   imageSourceType(dv),
+		VMs:          imageConsumers(dv.Name, vms),
+	}
+
+	spec := dv.Spec.PVC
+	if pvc != nil {
+		spec = &pvc.Spec
+		if capacity, ok := pvc.Status.Capacity[k8sv1.ResourceStorage]; ok {
+			img.Capacity = capacity.String()
+		}
+	}
+	if spec != nil {
+		if requested, ok := spec.Resources.Requests[k8sv1.ResourceStorage]; ok {
+			img.RequestedCapacity = requested.String()
+		}
+		if spec.StorageClassName != nil {
+			img.StorageClass = *spec.StorageClassName
+		}
+		for _, mode := range spec.AccessModes {
+			img.AccessModes = append(img.AccessModes, string(mode))
+		}
+		if spec.VolumeMode != nil {
+			img.VolumeMode = string(*spec.VolumeMode)
+		}
+	}
+	return img
+}
+
+// imageSourceType tells where the data of dv comes from.
+func imageSourceType(dv *cdiv1.DataVolume) string {
+	source := dv.Spec.Source
+	switch {
+	case source.HTTP != nil:
+		return ImportSourceHTTP
+	case source.Registry != nil:
+		return ImportSourceRegistry
+	case source.S3 != nil:
+		return "s3"
+	case source.PVC != nil:
+		return "clone"
+	case source.Upload != nil:
+		return "upload"
+	case source.Blank != nil:
+		return "blank"
+	}
+	return ""
+}
+
+// isImage tells whether dv is an image, rather than a DataVolume of a snapshot or one a VM owns, like the disks
+// created from its DataVolumeTemplates.
+func isImage(dv *cdiv1.DataVolume) bool {
+	_, snapshot := dv.Labels[snapshotLabel]
+	return !snapshot && ownerVM(dv) == ""
+}
+
+// getImage returns the DataVolume of the image name, NotFound if it is a DataVolume but not an image.
+func getImage(client kubecli.KubevirtClient, namespace, name string) (*cdiv1.DataVolume, error) {
+	dv, err := client.CdiClient().CdiV1alpha1().DataVolumes(namespace).Get(name, k8smetav1.GetOptions{})
+	if err == nil && !isImage(dv) {
+		err = newAPIError(http.StatusNotFound, ErrCodeNotFound, "%s is a disk of a VM or a snapshot, not an image", name)
+	}
+	return dv, err
+}
+
+// ownerVM returns the name of the VM owning dv, or an empty string if no VM does.
+func ownerVM(dv *cdiv1.DataVolume) string {
+	for _, ref := range dv.OwnerReferences {
+		if ref.Kind == v1.VirtualMachineGroupVersionKind.Kind && strings.HasPrefix(ref.APIVersion, v1.GroupName+"/") {
+			return ref.Name
+		}
+	}
+	return ""
+}
+
+// imageConsumers returns the names of the vms with a volume backed by the DataVolume name or its PVC.
+func imageConsumers(name string, vms []v1.VirtualMachine) []string {
+	var consumers []string
+	for _, vm := range vms {
+		if vm.Spec.Template == nil {
+			continue
+		}
+		for _, volume := range vm.Spec.Template.Spec.Volumes {
+			if (volume.DataVolume != nil && volume.DataVolume.Name == name) ||
+				(volume.PersistentVolumeClaim != nil && volume.PersistentVolumeClaim.ClaimName == name) {
+				consumers = append(consumers, vm.Name)
+				break
+			}
+		}
+	}
+	return consumers
+}
+
 type JsonResponseListImageSuccess struct {
 	StatusCode int
 	Message    string
@@ -125,88 +262,404 @@ type JsonResponseListImageSuccess struct {
 }
 
 // @Title Upload Image
-// @Description Upload a new image.
+// @Description Start uploading a new image from a file in the uploaddir directory of the server. The upload runs in the background, poll the returned job for its progress.
 // @Param	body	body	controllers.JsonRequestUploadImage	true	"The image content"
-// @Success 200 {object} controllers.JsonResponseUploadImageSuccess
+// @Success 202 {object} controllers.JsonResponseUploadImageSuccess
+// @Failure 400 Bad upload request.
+// @Failure 403 Forbidden by the RBAC rules of the cluster, or uploads from server files are disabled.
 // @Failure 500 Failed to upload image.
 // @router / [post]
 func (i *ImageController) Post() {
 	var jsonReq JsonRequestUploadImage
 	json.Unmarshal(i.Ctx.Input.RequestBody, &jsonReq)
 
-	insecure := true
-	uploadProxyUrl := jsonReq.UploadProxyUrl
-	name := jsonReq.Name
-	size := jsonReq.Size
-	imagePath := jsonReq.FilePath
-	accessMode := "ReadWriteOnce"
-	uploadPodWaitSecs := uint(240)
+	namespace, virtClient, err := i.GetVirtClient()
+	if err != nil {
+		i.ResponseNotAvaliable(err)
+		return
+	}
 
-	err := imageupload.UploadImage(insecure, uploadProxyUrl, name, size, imagePath, accessMode, uploadPodWaitSecs)
+	name := jsonReq.Name
+	imagePath, err := uploadFilePath(beego.AppConfig.String("uploaddir"), jsonReq.FilePath)
+	if err != nil {
+		i.SetError("Bad upload request.", err)
+		i.ServeJSON()
+		return
+	}
+	opts, err := uploadOptions(*virtClient, &jsonReq)
+	if err != nil {
+		i.SetError("Bad upload request.", badRequest(err))
+		i.ServeJSON()
+		return
+	}
+	uploader := imageupload.NewUploader(*virtClient, (*virtClient).CdiClient(), *namespace)
 
-	if err == nil {
-		i.Data["json"] = JsonResponseUploadImageSuccess{200, name + " upload success.",
-			JsonRequestUploadImage{name, imagePath, uploadProxyUrl, size}}
-	} else {
-		i.Ctx.Output.SetStatus(500)
-		i.Data["json"] = JsonResponseBasic{500, "Failed to upload " + name + ". " + err.Error()}
+	file, err := os.Open(imagePath)
+	if err != nil {
+		i.SetError("Failed to upload "+name+".", err)
+		i.ServeJSON()
+		return
+	}
+	fi, err := file.Stat()
+	if err == nil && !fi.Mode().IsRegular() {
+		err = badRequest(fmt.Errorf("%s is not a regular file", jsonReq.FilePath))
+	}
+	if err != nil {
+		file.Close()
+		i.SetError("Failed to upload "+name+".", err)
+		i.ServeJSON()
+		return
 	}
+
+	jobID, _ := imageJobs.start(i.jobOwner(), name, jsonReq.FilePath, func(ctx context.Context, progress imageupload.ProgressFunc) error {
+		defer file.Close()
+		opts.Source = jsonReq.FilePath
+		opts.Progress = progress
+		return uploader.Upload(ctx, opts, file, fi.Size())
+	})
+
+	i.Ctx.Output.SetStatus(202)
+	i.Data["json"] = JsonResponseUploadImageSuccess{202, name + " upload started.", jsonReq, jobID}
 	i.ServeJSON()
 }
 
 type JsonRequestUploadImage struct {
-	Name           string
+	Name string
+	// FilePath is the file to upload, relative to the uploaddir directory of app.conf or absolute within it
 	FilePath       string
 	UploadProxyUrl string
 	Size           string
+	// The storage settings below default to the image* settings of app.conf.
+	StorageClass string
+	// AccessMode is ReadWriteOnce (RWO), ReadWriteMany (RWX) or ReadOnlyMany (ROX)
+	AccessMode string
+	// VolumeMode is Filesystem or Block
+	VolumeMode string
+	// The upload settings below default to the upload* settings of app.conf.
+	InsecureSkipVerify *bool
+	UploadPodWaitSecs  uint
+}
+
+// uploadFilePath resolves path, relative to dir or absolute, and returns it if it is a file within dir once the
+// symbolic links are followed. Uploads from server files are disabled when dir is empty.
+func uploadFilePath(dir, path string) (string, error) {
+	if dir == "" {
+		return "", newAPIError(http.StatusForbidden, ErrCodeForbidden, "uploads from server files are disabled, set uploaddir to enable them")
+	}
+	if path == "" {
+		return "", newAPIError(http.StatusBadRequest, ErrCodeBadRequest, "FilePath is required")
+	}
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", wrapError(err, "invalid uploaddir")
+	}
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return "", wrapError(err, "invalid uploaddir")
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", newAPIError(http.StatusBadRequest, ErrCodeBadRequest, "cannot find %s in the upload directory", path)
+	}
+	resolved, err = filepath.Abs(resolved)
+	if err != nil {
+		return "", badRequest(err)
+	}
+	if rel, err := filepath.Rel(root, resolved); err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", newAPIError(http.StatusBadRequest, ErrCodeBadRequest, "%s is not in the upload directory", path)
+	}
+	return resolved, nil
+}
+
+// uploadFields are the fields of JsonRequestUploadImage a streamed upload takes from its
+// query parameters or multipart fields.
+var uploadFields = []string{"Name", "Size", "UploadProxyUrl", "StorageClass", "AccessMode", "VolumeMode",
+	"InsecureSkipVerify", "UploadPodWaitSecs"}
+
+// setUploadField sets the uploadFields field of jsonReq from its string value.
+func setUploadField(jsonReq *JsonRequestUploadImage, field, value string) error {
+	switch field {
+	case "Name":
+		jsonReq.Name = value
+	case "Size":
+		jsonReq.Size = value
+	case "UploadProxyUrl":
+		jsonReq.UploadProxyUrl = value
+	case "StorageClass":
+		jsonReq.StorageClass = value
+	case "AccessMode":
+		jsonReq.AccessMode = value
+	case "VolumeMode":
+		jsonReq.VolumeMode = value
+	case "InsecureSkipVerify":
+		insecure, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid InsecureSkipVerify %s", value)
+		}
+		jsonReq.InsecureSkipVerify = &insecure
+	case "UploadPodWaitSecs":
+		secs, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid UploadPodWaitSecs %s", value)
+		}
+		jsonReq.UploadPodWaitSecs = uint(secs)
+	}
+	return nil
+}
+
+var accessModeAbbreviations = map[string]k8sv1.PersistentVolumeAccessMode{
+	"RWO": k8sv1.ReadWriteOnce,
+	"RWX": k8sv1.ReadWriteMany,
+	"ROX": k8sv1.ReadOnlyMany,
+}
+
+// storageSettings fills the unset storage settings of a new PVC with the image* defaults of app.conf,
+// expands the abbreviated access modes and validates them.
+func storageSettings(storageClass, accessMode, volumeMode *string) error {
+	if *storageClass == "" {
+		*storageClass = beego.AppConfig.String("imagestorageclass")
+	}
+	if *accessMode == "" {
+		*accessMode = beego.AppConfig.DefaultString("imageaccessmode", string(k8sv1.ReadWriteOnce))
+	}
+	if *volumeMode == "" {
+		*volumeMode = beego.AppConfig.String("imagevolumemode")
+	}
+
+	if mode, ok := accessModeAbbreviations[strings.ToUpper(*accessMode)]; ok {
+		*accessMode = string(mode)
+	}
+	switch k8sv1.PersistentVolumeAccessMode(*accessMode) {
+	case k8sv1.ReadWriteOnce, k8sv1.ReadWriteMany, k8sv1.ReadOnlyMany:
+	default:
+		return fmt.Errorf("unknown AccessMode %s", *accessMode)
+	}
+	switch k8sv1.PersistentVolumeMode(*volumeMode) {
+	case "", k8sv1.PersistentVolumeFilesystem, k8sv1.PersistentVolumeBlock:
+	default:
+		return fmt.Errorf("unknown VolumeMode %s", *volumeMode)
+	}
+	return nil
+}
+
+// uploadOptions fills the unset settings of jsonReq with the defaults of app.conf, validates
+// them against the cluster and returns them as upload options.
+func uploadOptions(client kubecli.KubevirtClient, jsonReq *JsonRequestUploadImage) (imageupload.Options, error) {
+	if err := storageSettings(&jsonReq.StorageClass, &jsonReq.AccessMode, &jsonReq.VolumeMode); err != nil {
+		return imageupload.Options{}, err
+	}
+	if jsonReq.InsecureSkipVerify == nil {
+		insecure := beego.AppConfig.DefaultBool("uploadinsecureskipverify", true)
+		jsonReq.InsecureSkipVerify = &insecure
+	}
+	if jsonReq.UploadPodWaitSecs == 0 {
+		jsonReq.UploadPodWaitSecs = uint(beego.AppConfig.DefaultInt("uploadpodwaitsecs", 240))
+	}
+
+	if jsonReq.StorageClass != "" {
+		// Users that may not read storage classes get the error of the PVC instead
+		if _, err := client.StorageV1().StorageClasses().Get(jsonReq.StorageClass, k8smetav1.GetOptions{}); k8serrors.IsNotFound(err) {
+			return imageupload.Options{}, fmt.Errorf("storage class %s is not available: %v", jsonReq.StorageClass, err)
+		}
+	}
+
+	return imageupload.Options{
+		Name:              jsonReq.Name,
+		Size:              jsonReq.Size,
+		AccessMode:        jsonReq.AccessMode,
+		StorageClass:      jsonReq.StorageClass,
+		VolumeMode:        jsonReq.VolumeMode,
+		UploadProxyURL:    jsonReq.UploadProxyUrl,
+		Insecure:          *jsonReq.InsecureSkipVerify,
+		UploadPodWaitSecs: jsonReq.UploadPodWaitSecs,
+		Source:            jsonReq.FilePath,
+	}, nil
+}
+
+// jobOwner is the user the upload jobs of the request belong to, empty without authentication.
+func (i *ImageController) jobOwner() string {
+	if identity := i.Identity(); identity != nil {
+		return identity.Name
+	}
+	return ""
 }
 
 type JsonResponseUploadImageSuccess struct {
 	StatusCode int
 	Message    string
 	Image      JsonRequestUploadImage
+	JobID      string
+}
+
+// @Title Get Upload Job
+// @Description Get the phase and progress of an image upload job.
+// @Param	JobID	path	string	true	"The job returned when the upload was started"
+// @Success 200 {object} controllers.JsonResponseImageJobSuccess
+// @Failure 404 Job not found.
+// @router /jobs/:JobID [get]
+func (i *ImageController) GetJob() {
+	jobID := i.Ctx.Input.Param(":JobID")
+	job, ok := imageJobs.get(jobID, i.jobOwner())
+	if ok {
+		i.Data["json"] = JsonResponseImageJobSuccess{200, "Job " + jobID + " get success.", job}
+	} else {
+		i.SetError("", newAPIError(404, ErrCodeNotFound, "Job %s not found.", jobID))
+	}
+	i.ServeJSON()
+}
+
+// @Title Cancel Upload Job
+// @Description Cancel a running image upload job. The DataVolume created by the job is deleted, unless all the data was sent already and it is being processed.
+// @Param	JobID	path	string	true	"The job returned when the upload was started"
+// @Success 200 {object} controllers.JsonResponseBasic
+// @Failure 404 Job not found.
+// @router /jobs/:JobID [delete]
+func (i *ImageController) CancelJob() {
+	jobID := i.Ctx.Input.Param(":JobID")
+	if imageJobs.cancel(jobID, i.jobOwner()) {
+		i.Data["json"] = JsonResponseBasic{StatusCode: 200, Message: "Job " + jobID + " cancel success."}
+	} else {
+		i.SetError("", newAPIError(404, ErrCodeNotFound, "Job %s not found.", jobID))
+	}
+	i.ServeJSON()
+}
+
+type JsonResponseImageJobSuccess struct {
+	StatusCode int
+	Message    string
+	Job        models.ImageJob
+}
+
+// streamBodyKey is the context data key under which StreamRequestBody keeps the raw request body.
+const streamBodyKey = "StreamRequestBody"
+
+// maxUploadFieldSize bounds the size of a non-file part of a multipart upload.
+const maxUploadFieldSize = 4096
+
+// StreamRequestBody is a filter that takes the request body away from beego before it copies
+// or parses it, so the handler can stream it. Without it the body would be read into memory
+// (copyrequestbody) or staged on disk (multipart forms). It must run at beego.BeforeStatic.
+func StreamRequestBody(ctx *beecontext.Context) {
+	if ctx.Request.Method != http.MethodPost || ctx.Request.Body == nil {
+		return
+	}
+	ctx.Input.SetData(streamBodyKey, ctx.Request.Body)
+	ctx.Request.Body = http.NoBody
+}
+
+// @Title Stream Upload Image
+// @Description Upload a new image by streaming it in the request body, either as raw bytes (application/octet-stream) or as the file part of a multipart/form-data request. Multipart fields sent before the file part override the query parameters. The request returns once the data has been sent, poll the returned job for the processing that follows.
+// @Param	Name	query	string	true	"The image name"
+// @Param	Size	query	string	true	"The size of the image data volume, e.g. 10Gi"
+// @Param	UploadProxyUrl	query	string	false	"The CDI upload proxy url"
+// @Param	StorageClass	query	string	false	"The storage class of the image"
+// @Param	AccessMode	query	string	false	"The access mode of the image, RWO, RWX or ROX"
+// @Param	VolumeMode	query	string	false	"The volume mode of the image, Filesystem or Block"
+// @Param	InsecureSkipVerify	query	bool	false	"Skip verifying the upload proxy certificate"
+// @Param	UploadPodWaitSecs	query	int	false	"How long to wait for the upload pod"
+// @Success 202 {object} controllers.JsonResponseUploadImageSuccess
+// @Failure 400 Bad upload request.
+// @Failure 403 Forbidden by the RBAC rules of the cluster.
+// @Failure 500 Failed to upload image.
+// @router /upload [post]
+func (i *ImageController) Upload() {
+	body, ok := i.Ctx.Input.GetData(streamBodyKey).(io.ReadCloser)
+	if !ok {
+		i.SetError("", newAPIError(400, ErrCodeBadRequest, "Request body is not available for streaming."))
+		i.ServeJSON()
+		return
+	}
+	defer body.Close()
+
+	var jsonReq JsonRequestUploadImage
+	var err error
+	for _, field := range uploadFields {
+		if value := i.GetString(field); value != "" && err == nil {
+			err = setUploadField(&jsonReq, field, value)
+		}
+	}
+	var reader io.Reader
+	var length int64
+	if err == nil {
+		reader, length, err = openUploadStream(i.Ctx, body, &jsonReq)
+	}
+	if err != nil {
+		i.SetError("Bad upload request.", badRequest(err))
+		i.ServeJSON()
+		return
+	}
+	if jsonReq.Name == "" || jsonReq.Size == "" {
+		i.SetError("", newAPIError(400, ErrCodeBadRequest, "Bad upload request. Name and Size are required."))
+		i.ServeJSON()
+		return
+	}
+
+	namespace, virtClient, err := i.GetVirtClient()
+	if err != nil {
+		i.ResponseNotAvaliable(err)
+		return
+	}
+	opts, err := uploadOptions(*virtClient, &jsonReq)
+	if err != nil {
+		i.SetError("Bad upload request.", badRequest(err))
+		i.ServeJSON()
+		return
+	}
+	uploader := imageupload.NewUploader(*virtClient, (*virtClient).CdiClient(), *namespace)
+
+	jobID, transferred := imageJobs.start(i.jobOwner(), jsonReq.Name, jsonReq.FilePath, func(ctx context.Context, progress imageupload.ProgressFunc) error {
+		opts.Progress = progress
+		return uploader.Upload(ctx, opts, reader, length)
+	})
+	// The body belongs to this request, so it has to stay open until the upload is done reading it.
+	<-transferred
+
+	job, _ := imageJobs.get(jobID, i.jobOwner())
+	if job.Phase != string(imageupload.PhaseFailed) {
+		i.Ctx.Output.SetStatus(202)
+		i.Data["json"] = JsonResponseUploadImageSuccess{202, jsonReq.Name + " upload transferred.", jsonReq, jobID}
+	} else {
+		i.SetError("Failed to upload "+jsonReq.Name+".", imageJobs.failure(jobID))
+	}
+	i.ServeJSON()
 }
 
-// // @Title Rename Image
-// // @Description Rename an exist image.
-// // @Param	ImageName	path 	string	true		"The image you want to rename"
-// // @Param	body	body	controllers.JsonRequestRename	true	"The new name"
-// // @Success 200 {object} controllers.JsonResponseRenameSuccess
-// // @Failure 500 Failed to rename image.
-// // @router /:ImageName [put]
-// func (i *ImageController) Put() {
-// 	ok, namespace, virtClient := GetVirtClient()
-// 	if !ok {
-// 		i.ResponseNotAvaliable()
-// 		return
-// 	}
-
-// 	imgName := i.Ctx.Input.Param(":ImageName")
-// 	var jsonReq JsonRequestRename
-// 	json.Unmarshal(i.Ctx.Input.RequestBody, &jsonReq)
-// 	newName := jsonReq.NewName
-
-// 	img, err := (*virtClient).CdiClient().CdiV1alpha1().DataVolumes(*namespace).Get(imgName, k8smetav1.GetOptions{})
-
-// 	if err == nil {
-// 		newImg := img.DeepCopy()
-// 		newImg.SetName(newName)
-// 		err = (*virtClient).CdiClient().CdiV1alpha1().DataVolumes(*namespace).Delete(imgName, &k8smetav1.DeleteOptions{})
-// 		if err == nil {
-// 			_, err = (*virtClient).CdiClient().CdiV1alpha1().DataVolumes(*namespace).Create(newImg)
-// 			if err == nil {
-// 				i.Data["json"] = JsonResponseRenameSuccess{200, "Rename " + imgName + " to " + newName + " success.", newName}
-// 			} else {
-// 				fmt.Printf("%s\n", err)
-// 			}
-// 		}
-// 	}
-// 	if err != nil {
-//		i.Ctx.Output.SetStatus(500)
-// 		i.Data["json"] = JsonResponseBasic{500, "Failed to rename " + imgName + " to " + newName + ". " + err.Error()}
-// 	}
-// 	i.ServeJSON()
-// }
+// openUploadStream returns the image data in body and its length, or -1 if the length is unknown.
+// For multipart requests the fields preceding the file part are read into jsonReq, and
+// jsonReq.FilePath is set to the name of the uploaded file.
+func openUploadStream(ctx *beecontext.Context, body io.Reader, jsonReq *JsonRequestUploadImage) (io.Reader, int64, error) {
+	mediaType, params, err := mime.ParseMediaType(ctx.Input.Header("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		jsonReq.FilePath = "request body"
+		return body, ctx.Request.ContentLength, nil
+	}
+
+	mr := multipart.NewReader(body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, 0, fmt.Errorf("no file part in multipart request")
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if part.FileName() != "" {
+			jsonReq.FilePath = part.FileName()
+			return part, -1, nil
+		}
+
+		value, err := ioutil.ReadAll(io.LimitReader(part, maxUploadFieldSize))
+		if err != nil {
+			return nil, 0, err
+		}
+		if err := setUploadField(jsonReq, part.FormName(), string(value)); err != nil {
+			return nil, 0, err
+		}
+	}
+}
 
 type JsonRequestRename struct {
 	NewName string
@@ -222,23 +675,27 @@ type JsonResponseRenameSuccess struct {
 // @Description Delete an exist image.
 // @Param	ImageName	path	string	true	"The image you want to delete"
 // @Success 200 {object} controllers.JsonResponseBasic
+// @Failure 403 Forbidden by the RBAC rules of the cluster.
+// @Failure 404 Image not found.
 // @Failure 500 Failed to delete image.
 // @router /:ImageName [delete]
 func (i *ImageController) Delete() {
-	ok, namespace, virtClient := GetVirtClient()
-	if !ok {
-		i.ResponseNotAvaliable()
+	namespace, virtClient, err := i.GetVirtClient()
+	if err != nil {
+		i.ResponseNotAvaliable(err)
 		return
 	}
 
 	imgName := i.Ctx.Input.Param(":ImageName")
-	err := (*virtClient).CdiClient().CdiV1alpha1().DataVolumes(*namespace).Delete(imgName, &k8smetav1.DeleteOptions{})
+	_, err = getImage(*virtClient, *namespace, imgName)
+	if err == nil {
+		err = (*virtClient).CdiClient().CdiV1alpha1().DataVolumes(*namespace).Delete(imgName, &k8smetav1.DeleteOptions{})
+	}
 
 	if err == nil {
-		i.Data["json"] = JsonResponseBasic{200, imgName + " delete success."}
+		i.Data["json"] = JsonResponseBasic{StatusCode: 200, Message: imgName + " delete success."}
 	} else {
-		i.Ctx.Output.SetStatus(500)
-		i.Data["json"] = JsonResponseBasic{500, "Failed to delete " + imgName + ". " + err.Error()}
+		i.SetError("Failed to delete "+imgName+".", err)
 	}
 	i.ServeJSON()
 }
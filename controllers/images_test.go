@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	k8smetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	cdifake "kubevirt.io/client-go/generated/containerized-data-importer/clientset/versioned/fake"
+	cdiv1 "kubevirt.io/containerized-data-importer/pkg/apis/core/v1alpha1"
+)
+
+func TestUploadFilePath(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "uploaddir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	dir := filepath.Join(tmp, "images")
+	if err := os.MkdirAll(filepath.Join(dir, "iso"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{filepath.Join(dir, "ubuntu.img"), filepath.Join(dir, "iso", "ubuntu.iso"), filepath.Join(tmp, "secret")} {
+		if err := ioutil.WriteFile(name, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(tmp, "secret"), filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		dir, path    string
+		expected     string
+		expectedCode string
+	}{
+		{dir: dir, path: "ubuntu.img", expected: filepath.Join(dir, "ubuntu.img")},
+		{dir: dir, path: "iso/ubuntu.iso", expected: filepath.Join(dir, "iso", "ubuntu.iso")},
+		{dir: dir, path: filepath.Join(dir, "ubuntu.img"), expected: filepath.Join(dir, "ubuntu.img")},
+		{dir: dir, path: "iso/../ubuntu.img", expected: filepath.Join(dir, "ubuntu.img")},
+		{dir: dir, path: "../secret", expectedCode: ErrCodeBadRequest},
+		{dir: dir, path: filepath.Join(tmp, "secret"), expectedCode: ErrCodeBadRequest},
+		{dir: dir, path: "link", expectedCode: ErrCodeBadRequest},
+		{dir: dir, path: ".", expectedCode: ErrCodeBadRequest},
+		{dir: dir, path: "missing.img", expectedCode: ErrCodeBadRequest},
+		{dir: dir, path: "", expectedCode: ErrCodeBadRequest},
+		{dir: "", path: filepath.Join(dir, "ubuntu.img"), expectedCode: ErrCodeForbidden},
+	} {
+		got, err := uploadFilePath(test.dir, test.path)
+		if test.expectedCode != "" {
+			if code := toAPIError(err).Code; code != test.expectedCode {
+				t.Errorf("%q in %q: got %q, %v, expected a %s error", test.path, test.dir, got, err, test.expectedCode)
+			}
+			continue
+		}
+		expected, _ := filepath.EvalSymlinks(test.expected)
+		if err != nil || got != expected {
+			t.Errorf("%q in %q: got %q, %v, expected %q", test.path, test.dir, got, err, expected)
+		}
+	}
+}
+
+func TestIsImage(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		dv       cdiv1.DataVolume
+		expected bool
+	}{
+		{name: "image", dv: cdiv1.DataVolume{}, expected: true},
+		{name: "snapshot", dv: cdiv1.DataVolume{ObjectMeta: k8smetav1.ObjectMeta{
+			Labels: map[string]string{snapshotLabel: "nightly"},
+		}}},
+		{name: "VM disk", dv: cdiv1.DataVolume{ObjectMeta: k8smetav1.ObjectMeta{
+			OwnerReferences: []k8smetav1.OwnerReference{{APIVersion: "kubevirt.io/v1alpha3", Kind: "VirtualMachine", Name: "vm"}},
+		}}},
+		{name: "owned by something else", dv: cdiv1.DataVolume{ObjectMeta: k8smetav1.ObjectMeta{
+			OwnerReferences: []k8smetav1.OwnerReference{{APIVersion: "example.com/v1", Kind: "VirtualMachine", Name: "vm"}},
+		}}, expected: true},
+	} {
+		if got := isImage(&test.dv); got != test.expected {
+			t.Errorf("%s: got %v, expected %v", test.name, got, test.expected)
+		}
+	}
+}
+
+func TestGetImage(t *testing.T) {
+	client := fakeVirtClient{cdi: cdifake.NewSimpleClientset(
+		&cdiv1.DataVolume{ObjectMeta: k8smetav1.ObjectMeta{Name: "ubuntu", Namespace: "ns"}},
+		&cdiv1.DataVolume{ObjectMeta: k8smetav1.ObjectMeta{
+			Name:            "web-dvdisk",
+			Namespace:       "ns",
+			OwnerReferences: []k8smetav1.OwnerReference{{APIVersion: "kubevirt.io/v1alpha3", Kind: "VirtualMachine", Name: "web"}},
+		}},
+		&cdiv1.DataVolume{ObjectMeta: k8smetav1.ObjectMeta{
+			Name:      "nightly-dvdisk",
+			Namespace: "ns",
+			Labels:    map[string]string{snapshotLabel: "nightly"},
+		}},
+	)}
+
+	if dv, err := getImage(client, "ns", "ubuntu"); err != nil || dv.Name != "ubuntu" {
+		t.Errorf("got %v, %v, expected the image ubuntu", dv, err)
+	}
+	for _, name := range []string{"web-dvdisk", "nightly-dvdisk", "missing"} {
+		if _, err := getImage(client, "ns", name); toAPIError(err).Code != ErrCodeNotFound {
+			t.Errorf("%s: got %v, expected NotFound", name, err)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package controllers
+
+import (
+	"net/http"
+	"sort"
+	"time"
+	"virt-webui/models"
+
+	k8sv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	k8smetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1 "kubevirt.io/client-go/api/v1"
+)
+
+// Operations about live migration
+type MigrationController struct {
+	baseController
+}
+
+// @Title Migrate VM
+// @Description Start a live migration of a running virtual machine to another node chosen by the scheduler. Poll the migration for its progress.
+// @Param	VMName	path	string	true	"The VM you want to migrate"
+// @Success 202 {object} controllers.JsonResponseMigrationSuccess
+// @Failure 403 Forbidden by the RBAC rules of the cluster.
+// @Failure 404 VM not running.
+// @Failure 409 VM not live migratable.
+// @Failure 500 Failed to migrate VM.
+// @router /:VMName/migrate [post]
+func (v *VMController) Migrate() {
+	namespace, virtClient, err := v.GetVirtClient()
+	if err != nil {
+		v.ResponseNotAvaliable(err)
+		return
+	}
+
+	vmName := v.Ctx.Input.Param(":VMName")
+	vmi, err := (*virtClient).VirtualMachineInstance(*namespace).Get(vmName, &k8smetav1.GetOptions{})
+	if err == nil {
+		if condition := vmiCondition(vmi, v1.VirtualMachineInstanceIsMigratable); condition != nil && condition.Status == k8sv1.ConditionFalse {
+			err = newAPIError(http.StatusConflict, ErrCodeConflict, "%s is not live migratable: %s", vmName, condition.Message)
+		}
+	} else if k8serrors.IsNotFound(err) {
+		err = newAPIError(http.StatusNotFound, ErrCodeNotFound, "%s is not running.", vmName)
+	}
+	if err != nil {
+		v.SetError("Failed to migrate "+vmName+".", err)
+		v.ServeJSON()
+		return
+	}
+
+	migration, err := (*virtClient).VirtualMachineInstanceMigration(*namespace).Create(&v1.VirtualMachineInstanceMigration{
+		ObjectMeta: k8smetav1.ObjectMeta{
+			GenerateName: vmName + "-migration-",
+		},
+		Spec: v1.VirtualMachineInstanceMigrationSpec{
+			VMIName: vmName,
+		},
+	})
+	if err == nil {
+		v.Ctx.Output.SetStatus(202)
+		v.Data["json"] = JsonResponseMigrationSuccess{202, vmName + " migration started.", newMigration(migration, vmi)}
+	} else {
+		v.SetError("Failed to migrate "+vmName+".", err)
+	}
+	v.ServeJSON()
+}
+
+type JsonResponseMigrationSuccess struct {
+	StatusCode int
+	Message    string
+	Migration  models.Migration
+}
+
+// @Title List Migration
+// @Description List the live migrations, the newest first.
+// @Param	vm	query	string	false	"Only the migrations of this VM"
+// @Success 200 {object} controllers.JsonResponseListMigrationSuccess
+// @Failure 403 Forbidden by the RBAC rules of the cluster.
+// @Failure 500 Failed to list migrations.
+// @router / [get]
+func (m *MigrationController) GetAll() {
+	namespace, virtClient, err := m.GetVirtClient()
+	if err != nil {
+		m.ResponseNotAvaliable(err)
+		return
+	}
+
+	vmName := m.GetString("vm")
+	migrationList, err := (*virtClient).VirtualMachineInstanceMigration(*namespace).List(&k8smetav1.ListOptions{})
+	if err != nil {
+		m.SetError("Failed to list migrations.", err)
+		m.ServeJSON()
+		return
+	}
+	// The progress of the migrations is in the status of the instances
+	instances := map[string]*v1.VirtualMachineInstance{}
+	if vmiList, err := (*virtClient).VirtualMachineInstance(*namespace).List(&k8smetav1.ListOptions{}); err == nil {
+		for i := range vmiList.Items {
+			instances[vmiList.Items[i].Name] = &vmiList.Items[i]
+		}
+	}
+
+	var migrations []models.Migration
+	for i := range migrationList.Items {
+		migration := &migrationList.Items[i]
+		if vmName == "" || migration.Spec.VMIName == vmName {
+			migrations = append(migrations, newMigration(migration, instances[migration.Spec.VMIName]))
+		}
+	}
+	sort.SliceStable(migrations, func(i, j int) bool {
+		return migrations[i].CreationTime.After(migrations[j].CreationTime)
+	})
+	m.Data["json"] = JsonResponseListMigrationSuccess{200, "Migrations list success.", migrations}
+	m.ServeJSON()
+}
+
+type JsonResponseListMigrationSuccess struct {
+	StatusCode int
+	Message    string
+	Migrations []models.Migration
+}
+
+// @Title Get Migration
+// @Description Get the phase and progress of a live migration.
+// @Param	MigrationName	path	string	true	"The migration you want to get"
+// @Success 200 {object} controllers.JsonResponseMigrationSuccess
+// @Failure 403 Forbidden by the RBAC rules of the cluster.
+// @Failure 404 Migration not found.
+// @Failure 500 Failed to get migration.
+// @router /:MigrationName [get]
+func (m *MigrationController) Get() {
+	namespace, virtClient, err := m.GetVirtClient()
+	if err != nil {
+		m.ResponseNotAvaliable(err)
+		return
+	}
+
+	name := m.Ctx.Input.Param(":MigrationName")
+	migration, err := (*virtClient).VirtualMachineInstanceMigration(*namespace).Get(name, &k8smetav1.GetOptions{})
+	if err == nil {
+		vmi, _ := (*virtClient).VirtualMachineInstance(*namespace).Get(migration.Spec.VMIName, &k8smetav1.GetOptions{})
+		m.Data["json"] = JsonResponseMigrationSuccess{200, name + " get success.", newMigration(migration, vmi)}
+	} else {
+		m.SetError("Failed to get "+name+".", err)
+	}
+	m.ServeJSON()
+}
+
+// @Title Cancel Migration
+// @Description Cancel a live migration that has not finished, the VM keeps running on its node.
+// @Param	MigrationName	path	string	true	"The migration you want to cancel"
+// @Success 200 {object} controllers.JsonResponseBasic
+// @Failure 403 Forbidden by the RBAC rules of the cluster.
+// @Failure 404 Migration not found.
+// @Failure 409 Migration already finished.
+// @Failure 500 Failed to cancel migration.
+// @router /:MigrationName [delete]
+func (m *MigrationController) Delete() {
+	namespace, virtClient, err := m.GetVirtClient()
+	if err != nil {
+		m.ResponseNotAvaliable(err)
+		return
+	}
+
+	name := m.Ctx.Input.Param(":MigrationName")
+	migrations := (*virtClient).VirtualMachineInstanceMigration(*namespace)
+	migration, err := migrations.Get(name, &k8smetav1.GetOptions{})
+	if err == nil {
+		switch migration.Status.Phase {
+		case v1.MigrationSucceeded, v1.MigrationFailed:
+			err = newAPIError(http.StatusConflict, ErrCodeConflict, "%s already %s.", name, migration.Status.Phase)
+		default:
+			// KubeVirt aborts the migrations deleted before they finished
+			err = migrations.Delete(name, &k8smetav1.DeleteOptions{})
+		}
+	}
+	if err == nil {
+		m.Data["json"] = JsonResponseBasic{StatusCode: 200, Message: name + " cancel success."}
+	} else {
+		m.SetError("Failed to cancel "+name+".", err)
+	}
+	m.ServeJSON()
+}
+
+// newMigration returns the state of migration, with its progress taken from vmi, the
+// instance it migrates, when it is the current migration of vmi.
+func newMigration(migration *v1.VirtualMachineInstanceMigration, vmi *v1.VirtualMachineInstance) models.Migration {
+	overview := models.Migration{
+		Name:         migration.Name,
+		Namespace:    migration.Namespace,
+		VM:           migration.Spec.VMIName,
+		Phase:        string(migration.Status.Phase),
+		CreationTime: migration.CreationTimestamp.Time,
+	}
+	if vmi == nil || vmi.Status.MigrationState == nil || vmi.Status.MigrationState.MigrationUID != migration.UID {
+		return overview
+	}
+	state := vmi.Status.MigrationState
+	overview.SourceNode = state.SourceNode
+	overview.TargetNode = state.TargetNode
+	overview.AbortStatus = string(state.AbortStatus)
+	if state.StartTimestamp != nil {
+		start := state.StartTimestamp.Time
+		overview.StartTime = &start
+		end := time.Now()
+		if state.EndTimestamp != nil {
+			end = state.EndTimestamp.Time
+			overview.EndTime = &end
+		}
+		overview.ElapsedSeconds = end.Sub(start).Seconds()
+	}
+	return overview
+}
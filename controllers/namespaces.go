@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	"virt-webui/models"
+
+	k8smetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Operations about namespace
+type NamespaceController struct {
+	baseController
+}
+
+// @Title List Namespace
+// @Description List all namespaces. VMs and images of a namespace are under /v1/namespaces/:ns/vms and /v1/namespaces/:ns/images.
+// @Success 200 {object} controllers.JsonResponseListNamespaceSuccess
+// @Failure 500 Failed to list namespaces.
+// @router / [get]
+func (n *NamespaceController) GetAll() {
+	namespace, virtClient, err := n.GetVirtClient()
+	if err != nil {
+		n.ResponseNotAvaliable(err)
+		return
+	}
+
+	nsList, err := (*virtClient).CoreV1().Namespaces().List(k8smetav1.ListOptions{})
+	if err != nil {
+		n.SetError("Failed to list namespaces.", err)
+		n.ServeJSON()
+		return
+	}
+
+	var namespaces []models.Namespace
+	for _, ns := range nsList.Items {
+		namespaces = append(namespaces, models.Namespace{Name: ns.Name, Status: string(ns.Status.Phase)})
+	}
+	n.Data["json"] = JsonResponseListNamespaceSuccess{200, "Namespaces list success.", namespaces, *namespace}
+	n.ServeJSON()
+}
+
+type JsonResponseListNamespaceSuccess struct {
+	StatusCode int
+	Message    string
+	Namespaces []models.Namespace
+	// DefaultNamespace is the namespace of the routes outside /v1/namespaces
+	DefaultNamespace string
+}
@@ -0,0 +1,584 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"virt-webui/models"
+
+	"github.com/astaxie/beego"
+	k8sv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	k8smetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/dynamic"
+	v1 "kubevirt.io/client-go/api/v1"
+	snapshotv1 "kubevirt.io/client-go/apis/snapshot/v1alpha1"
+	"kubevirt.io/client-go/kubecli"
+	cdiv1 "kubevirt.io/containerized-data-importer/pkg/apis/core/v1alpha1"
+)
+
+// The labels and annotations of the DataVolumes of the snapshots taken by cloning
+const (
+	snapshotLabel            = "virt-webui.io/snapshot"
+	snapshotVMLabel          = "virt-webui.io/snapshot-vm"
+	snapshotVolumeAnnotation = "virt-webui.io/snapshot-volume"
+	snapshotSourceAnnotation = "virt-webui.io/snapshot-source"
+)
+
+// vmRestoreResource is not in the KubeVirt client yet, restores are created with the dynamic client
+var vmRestoreResource = snapshotv1.SchemeGroupVersion.WithResource("virtualmachinerestores")
+
+// @Title List VM Snapshot
+// @Description List the snapshots of a virtual machine, the newest first.
+// @Param	VMName	path	string	true	"The VM whose snapshots you want to list"
+// @Success 200 {object} controllers.JsonResponseListSnapshotSuccess
+// @Failure 403 Forbidden by the RBAC rules of the cluster.
+// @Failure 500 Failed to list snapshots.
+// @router /:VMName/snapshots [get]
+func (v *VMController) ListSnapshots() {
+	namespace, virtClient, err := v.GetVirtClient()
+	if err != nil {
+		v.ResponseNotAvaliable(err)
+		return
+	}
+
+	vmName := v.Ctx.Input.Param(":VMName")
+	snapshots, err := listSnapshots(*virtClient, *namespace, vmName)
+	if err == nil {
+		sort.SliceStable(snapshots, func(i, j int) bool {
+			return snapshots[i].CreationTime.After(snapshots[j].CreationTime)
+		})
+		v.Data["json"] = JsonResponseListSnapshotSuccess{200, "Snapshots list success.", snapshots}
+	} else {
+		v.SetError("Failed to list snapshots of "+vmName+".", err)
+	}
+	v.ServeJSON()
+}
+
+type JsonResponseListSnapshotSuccess struct {
+	StatusCode int
+	Message    string
+	Snapshots  []models.Snapshot
+}
+
+// @Title Create VM Snapshot
+// @Description Take a snapshot of the disks of a virtual machine. KubeVirt snapshots are used when the cluster serves them, otherwise each DataVolume of the VM is cloned, which needs the VM to be stopped. List the snapshots for their progress.
+// @Param	VMName	path	string	true	"The VM you want to snapshot"
+// @Param	body	body	controllers.JsonRequestCreateSnapshot	false	"The snapshot"
+// @Success 202 {object} controllers.JsonResponseSnapshotSuccess
+// @Failure 400 Bad snapshot request.
+// @Failure 403 Forbidden by the RBAC rules of the cluster.
+// @Failure 404 VM not found.
+// @Failure 409 Snapshot already exists, or VM not stopped.
+// @Failure 500 Failed to snapshot VM.
+// @router /:VMName/snapshots [post]
+func (v *VMController) CreateSnapshot() {
+	namespace, virtClient, err := v.GetVirtClient()
+	if err != nil {
+		v.ResponseNotAvaliable(err)
+		return
+	}
+
+	vmName := v.Ctx.Input.Param(":VMName")
+	var jsonReq JsonRequestCreateSnapshot
+	json.Unmarshal(v.Ctx.Input.RequestBody, &jsonReq)
+	name := jsonReq.Name
+	if name == "" {
+		name = vmName + "-" + time.Now().Format("20060102150405")
+	}
+	// The name is a label value of the clones
+	if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+		v.SetError("Bad snapshot request.", newAPIError(http.StatusBadRequest, ErrCodeBadRequest,
+			"Name %s is invalid: %s", name, strings.Join(errs, ", ")))
+		v.ServeJSON()
+		return
+	}
+
+	var snapshot models.Snapshot
+	vm, err := (*virtClient).VirtualMachine(*namespace).Get(vmName, &k8smetav1.GetOptions{})
+	if err == nil {
+		var method string
+		method, err = snapshotMethod(*virtClient)
+		if err == nil && method == models.SnapshotMethodKubeVirt {
+			snapshot, err = snapshotByKubeVirt(*virtClient, vm, name)
+		} else if err == nil {
+			snapshot, err = snapshotByClone(*virtClient, vm, name)
+		}
+	}
+	if err == nil {
+		v.Ctx.Output.SetStatus(202)
+		v.Data["json"] = JsonResponseSnapshotSuccess{202, "Snapshot " + name + " of " + vmName + " started.", snapshot}
+	} else {
+		v.SetError("Failed to snapshot "+vmName+".", err)
+	}
+	v.ServeJSON()
+}
+
+type JsonRequestCreateSnapshot struct {
+	// Name of the snapshot, the VM name and the time if empty
+	Name string
+}
+
+type JsonResponseSnapshotSuccess struct {
+	StatusCode int
+	Message    string
+	Snapshot   models.Snapshot
+}
+
+// @Title Delete VM Snapshot
+// @Description Delete a snapshot of a virtual machine.
+// @Param	VMName	path	string	true	"The VM of the snapshot"
+// @Param	SnapshotName	path	string	true	"The snapshot you want to delete"
+// @Success 200 {object} controllers.JsonResponseBasic
+// @Failure 403 Forbidden by the RBAC rules of the cluster.
+// @Failure 404 Snapshot not found.
+// @Failure 500 Failed to delete snapshot.
+// @router /:VMName/snapshots/:SnapshotName [delete]
+func (v *VMController) DeleteSnapshot() {
+	namespace, virtClient, err := v.GetVirtClient()
+	if err != nil {
+		v.ResponseNotAvaliable(err)
+		return
+	}
+
+	vmName := v.Ctx.Input.Param(":VMName")
+	name := v.Ctx.Input.Param(":SnapshotName")
+	vmSnapshot, clones, err := getSnapshot(*virtClient, *namespace, vmName, name)
+	if err == nil && vmSnapshot != nil {
+		err = (*virtClient).VirtualMachineSnapshot(*namespace).Delete(name, &k8smetav1.DeleteOptions{})
+	} else if err == nil {
+		dataVolumes := (*virtClient).CdiClient().CdiV1alpha1().DataVolumes(*namespace)
+		for i := range clones {
+			if err = dataVolumes.Delete(clones[i].Name, &k8smetav1.DeleteOptions{}); err != nil && !k8serrors.IsNotFound(err) {
+				break
+			}
+			err = nil
+		}
+	}
+	if err == nil {
+		v.Data["json"] = JsonResponseBasic{StatusCode: 200, Message: name + " delete success."}
+	} else {
+		v.SetError("Failed to delete "+name+".", err)
+	}
+	v.ServeJSON()
+}
+
+// @Title Restore VM Snapshot
+// @Description Restore the disks of a stopped virtual machine to a snapshot. Snapshots taken by cloning are cloned back to new DataVolumes the VM is switched to, the previous ones are kept. The VM starts once they are populated.
+// @Param	VMName	path	string	true	"The VM you want to restore"
+// @Param	SnapshotName	path	string	true	"The snapshot to restore the VM to"
+// @Success 202 {object} controllers.JsonResponseRestoreSnapshotSuccess
+// @Failure 403 Forbidden by the RBAC rules of the cluster.
+// @Failure 404 Snapshot not found.
+// @Failure 409 Snapshot not ready, or VM not stopped.
+// @Failure 500 Failed to restore VM.
+// @Failure 501 Restores not supported by the KubeVirt of the cluster.
+// @router /:VMName/snapshots/:SnapshotName/restore [post]
+func (v *VMController) RestoreSnapshot() {
+	namespace, virtClient, err := v.GetVirtClient()
+	if err != nil {
+		v.ResponseNotAvaliable(err)
+		return
+	}
+
+	vmName := v.Ctx.Input.Param(":VMName")
+	name := v.Ctx.Input.Param(":SnapshotName")
+	var previous []string
+	vmSnapshot, clones, err := getSnapshot(*virtClient, *namespace, vmName, name)
+	if err == nil {
+		err = vmStopped(*virtClient, *namespace, vmName)
+	}
+	if err == nil && vmSnapshot != nil {
+		err = restoreByKubeVirt(*virtClient, vmSnapshot)
+	} else if err == nil {
+		previous, err = restoreByClone(*virtClient, *namespace, vmName, clones)
+	}
+	if err == nil {
+		v.Ctx.Output.SetStatus(202)
+		v.Data["json"] = JsonResponseRestoreSnapshotSuccess{202, "Restore " + vmName + " to " + name + " started.", previous}
+	} else {
+		v.SetError("Failed to restore "+vmName+" to "+name+".", err)
+	}
+	v.ServeJSON()
+}
+
+type JsonResponseRestoreSnapshotSuccess struct {
+	StatusCode int
+	Message    string
+	// PreviousDataVolumes are the DataVolumes the VM used before a restore from clones, delete them when no longer needed
+	PreviousDataVolumes []string
+}
+
+// snapshotMethod returns how snapshots are taken, as set by snapshotmethod in app.conf.
+// With auto, the default, KubeVirt snapshots are taken if the cluster serves them.
+func snapshotMethod(client kubecli.KubevirtClient) (string, error) {
+	switch method := beego.AppConfig.DefaultString("snapshotmethod", "auto"); strings.ToLower(method) {
+	case "kubevirt":
+		return models.SnapshotMethodKubeVirt, nil
+	case "clone":
+		return models.SnapshotMethodClone, nil
+	case "auto":
+		served, err := servesResource(client, snapshotv1.SchemeGroupVersion.WithResource("virtualmachinesnapshots"))
+		if served {
+			return models.SnapshotMethodKubeVirt, err
+		}
+		return models.SnapshotMethodClone, err
+	default:
+		return "", fmt.Errorf("unknown snapshotmethod %s", method)
+	}
+}
+
+// servesResource tells whether the API server of the cluster serves resource.
+func servesResource(client kubecli.KubevirtClient, resource schema.GroupVersionResource) (bool, error) {
+	resources, err := client.DiscoveryClient().ServerResourcesForGroupVersion(resource.GroupVersion().String())
+	if k8serrors.IsNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	for _, r := range resources.APIResources {
+		if r.Name == resource.Resource {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// vmStopped returns a Conflict error if the VM vmName has an instance.
+func vmStopped(client kubecli.KubevirtClient, namespace, vmName string) error {
+	_, err := client.VirtualMachineInstance(namespace).Get(vmName, &k8smetav1.GetOptions{})
+	if err == nil {
+		return newAPIError(http.StatusConflict, ErrCodeConflict, "%s must be stopped first.", vmName)
+	} else if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// listSnapshots returns the KubeVirt snapshots and the snapshots taken by cloning of the VM vmName.
+func listSnapshots(client kubecli.KubevirtClient, namespace, vmName string) ([]models.Snapshot, error) {
+	var snapshots []models.Snapshot
+	served, err := servesResource(client, snapshotv1.SchemeGroupVersion.WithResource("virtualmachinesnapshots"))
+	if err != nil {
+		return nil, err
+	}
+	if served {
+		snapshotList, err := client.VirtualMachineSnapshot(namespace).List(k8smetav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		// The volumes are in the contents, they are best effort
+		contents := map[string]*snapshotv1.VirtualMachineSnapshotContent{}
+		if contentList, err := client.VirtualMachineSnapshotContent(namespace).List(k8smetav1.ListOptions{}); err == nil {
+			for i := range contentList.Items {
+				contents[contentList.Items[i].Name] = &contentList.Items[i]
+			}
+		}
+		for i := range snapshotList.Items {
+			snapshot := &snapshotList.Items[i]
+			if isVMSnapshot(snapshot, vmName) {
+				var content *snapshotv1.VirtualMachineSnapshotContent
+				if snapshot.Status != nil && snapshot.Status.VirtualMachineSnapshotContentName != nil {
+					content = contents[*snapshot.Status.VirtualMachineSnapshotContentName]
+				}
+				snapshots = append(snapshots, newKubeVirtSnapshot(snapshot, content))
+			}
+		}
+	}
+
+	dvList, err := client.CdiClient().CdiV1alpha1().DataVolumes(namespace).List(k8smetav1.ListOptions{
+		LabelSelector: snapshotVMLabel + "=" + vmName,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(snapshots, newCloneSnapshots(dvList.Items)...), nil
+}
+
+// getSnapshot returns either the KubeVirt snapshot name of the VM vmName, or the DataVolumes of its snapshot name
+// taken by cloning. It returns a NotFound error if the VM has neither.
+func getSnapshot(client kubecli.KubevirtClient, namespace, vmName, name string) (*snapshotv1.VirtualMachineSnapshot, []cdiv1.DataVolume, error) {
+	served, err := servesResource(client, snapshotv1.SchemeGroupVersion.WithResource("virtualmachinesnapshots"))
+	if err != nil {
+		return nil, nil, err
+	}
+	if served {
+		snapshot, err := client.VirtualMachineSnapshot(namespace).Get(name, k8smetav1.GetOptions{})
+		if err == nil && isVMSnapshot(snapshot, vmName) {
+			return snapshot, nil, nil
+		} else if err != nil && !k8serrors.IsNotFound(err) {
+			return nil, nil, err
+		}
+	}
+
+	dvList, err := client.CdiClient().CdiV1alpha1().DataVolumes(namespace).List(k8smetav1.ListOptions{
+		LabelSelector: snapshotVMLabel + "=" + vmName + "," + snapshotLabel + "=" + name,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(dvList.Items) == 0 {
+		return nil, nil, newAPIError(http.StatusNotFound, ErrCodeNotFound, "%s has no snapshot %s.", vmName, name)
+	}
+	return nil, dvList.Items, nil
+}
+
+// isVMSnapshot tells whether snapshot is a snapshot of the VM vmName.
+func isVMSnapshot(snapshot *snapshotv1.VirtualMachineSnapshot, vmName string) bool {
+	source := snapshot.Spec.Source
+	return source.Kind == "VirtualMachine" && source.Name == vmName &&
+		source.APIGroup != nil && *source.APIGroup == v1.GroupName
+}
+
+// snapshotByKubeVirt creates the KubeVirt snapshot name of vm.
+func snapshotByKubeVirt(client kubecli.KubevirtClient, vm *v1.VirtualMachine, name string) (models.Snapshot, error) {
+	apiGroup := v1.GroupName
+	snapshot, err := client.VirtualMachineSnapshot(vm.Namespace).Create(&snapshotv1.VirtualMachineSnapshot{
+		ObjectMeta: k8smetav1.ObjectMeta{
+			Name: name,
+		},
+		Spec: snapshotv1.VirtualMachineSnapshotSpec{
+			Source: k8sv1.TypedLocalObjectReference{
+				APIGroup: &apiGroup,
+				Kind:     "VirtualMachine",
+				Name:     vm.Name,
+			},
+		},
+	})
+	if err != nil {
+		return models.Snapshot{}, err
+	}
+	return newKubeVirtSnapshot(snapshot, nil), nil
+}
+
+// snapshotByClone clones each DataVolume of the stopped vm to a DataVolume labelled with the snapshot name.
+// The clones already created are deleted if one cannot be.
+func snapshotByClone(client kubecli.KubevirtClient, vm *v1.VirtualMachine, name string) (models.Snapshot, error) {
+	if err := vmStopped(client, vm.Namespace, vm.Name); err != nil {
+		return models.Snapshot{}, err
+	}
+	dataVolumes := client.CdiClient().CdiV1alpha1().DataVolumes(vm.Namespace)
+	dvList, err := dataVolumes.List(k8smetav1.ListOptions{LabelSelector: snapshotVMLabel + "=" + vm.Name + "," + snapshotLabel + "=" + name})
+	if err != nil {
+		return models.Snapshot{}, err
+	}
+	if len(dvList.Items) > 0 {
+		return models.Snapshot{}, newAPIError(http.StatusConflict, ErrCodeAlreadyExists, "%s already has a snapshot %s.", vm.Name, name)
+	}
+
+	snapshot := models.Snapshot{
+		Name:         name,
+		Namespace:    vm.Namespace,
+		VM:           vm.Name,
+		Method:       models.SnapshotMethodClone,
+		CreationTime: time.Now(),
+	}
+	var clones []string
+	for _, volume := range vm.Spec.Template.Spec.Volumes {
+		if volume.DataVolume == nil {
+			continue
+		}
+		dv, err := newCloneDataVolume(client, vm.Namespace, volume.DataVolume.Name, vm.Namespace, name+"-"+volume.Name, "")
+		if err == nil {
+			dv.Labels = map[string]string{snapshotLabel: name, snapshotVMLabel: vm.Name}
+			dv.Annotations = map[string]string{snapshotVolumeAnnotation: volume.Name, snapshotSourceAnnotation: volume.DataVolume.Name}
+			_, err = dataVolumes.Create(dv)
+		}
+		if err != nil {
+			for _, clone := range clones {
+				dataVolumes.Delete(clone, &k8smetav1.DeleteOptions{})
+			}
+			return models.Snapshot{}, wrapError(err, "cannot clone volume %s", volume.Name)
+		}
+		clones = append(clones, dv.Name)
+		snapshot.Volumes = append(snapshot.Volumes, volume.Name)
+	}
+	if len(clones) == 0 {
+		return models.Snapshot{}, newAPIError(http.StatusBadRequest, ErrCodeBadRequest, "%s has no DataVolume to snapshot.", vm.Name)
+	}
+	return snapshot, nil
+}
+
+// restoreByKubeVirt creates a KubeVirt restore of the VM of snapshot.
+func restoreByKubeVirt(client kubecli.KubevirtClient, snapshot *snapshotv1.VirtualMachineSnapshot) error {
+	served, err := servesResource(client, vmRestoreResource)
+	if err != nil {
+		return err
+	}
+	if !served {
+		return newAPIError(http.StatusNotImplemented, ErrCodeNotImplemented, "The KubeVirt of the cluster cannot restore snapshots.")
+	}
+	if snapshot.Status == nil || snapshot.Status.ReadyToUse == nil || !*snapshot.Status.ReadyToUse {
+		return newAPIError(http.StatusConflict, ErrCodeConflict, "%s is not ready.", snapshot.Name)
+	}
+	dynamicClient, err := dynamic.NewForConfig(client.Config())
+	if err != nil {
+		return err
+	}
+	restore := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": snapshotv1.SchemeGroupVersion.String(),
+		"kind":       "VirtualMachineRestore",
+		"metadata": map[string]interface{}{
+			"generateName": snapshot.Name + "-restore-",
+		},
+		"spec": map[string]interface{}{
+			"target": map[string]interface{}{
+				"apiGroup": v1.GroupName,
+				"kind":     "VirtualMachine",
+				"name":     snapshot.Spec.Source.Name,
+			},
+			"virtualMachineSnapshotName": snapshot.Name,
+		},
+	}}
+	_, err = dynamicClient.Resource(vmRestoreResource).Namespace(snapshot.Namespace).Create(restore, k8smetav1.CreateOptions{})
+	return err
+}
+
+// restoreByClone clones the DataVolumes clones of a snapshot of the stopped VM vmName to new DataVolumes, and switches
+// the volumes of the VM to them. KubeVirt starts the VM only once they are populated. It returns the DataVolumes
+// the VM used before, they are kept.
+func restoreByClone(client kubecli.KubevirtClient, namespace, vmName string, clones []cdiv1.DataVolume) ([]string, error) {
+	vm, err := client.VirtualMachine(namespace).Get(vmName, &k8smetav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if vm.Spec.Template == nil {
+		return nil, newAPIError(http.StatusConflict, ErrCodeConflict, "%s has no volumes.", vmName)
+	}
+
+	suffix := time.Now().Format("20060102150405")
+	restores := map[string]*cdiv1.DataVolume{}
+	for i := range clones {
+		if clones[i].Status.Phase != cdiv1.Succeeded {
+			return nil, newAPIError(http.StatusConflict, ErrCodeConflict, "%s is not ready.", clones[i].Labels[snapshotLabel])
+		}
+		volumeName := clones[i].Annotations[snapshotVolumeAnnotation]
+		dv, err := newCloneDataVolume(client, namespace, clones[i].Name, namespace, vmName+"-"+volumeName+"-"+suffix, "")
+		if err != nil {
+			return nil, wrapError(err, "cannot clone volume %s", volumeName)
+		}
+		restores[volumeName] = dv
+	}
+	previous, err := restoreVolumes(vm, restores)
+	if err != nil {
+		return nil, err
+	}
+
+	dataVolumes := client.CdiClient().CdiV1alpha1().DataVolumes(namespace)
+	var restored []string
+	for volumeName, dv := range restores {
+		if _, err = dataVolumes.Create(dv); err != nil {
+			err = wrapError(err, "cannot clone volume %s", volumeName)
+			break
+		}
+		restored = append(restored, dv.Name)
+	}
+	if err == nil {
+		_, err = client.VirtualMachine(namespace).Update(vm)
+	}
+	if err != nil {
+		for _, name := range restored {
+			dataVolumes.Delete(name, &k8smetav1.DeleteOptions{})
+		}
+		return nil, err
+	}
+	return previous, nil
+}
+
+// restoreVolumes switches the volumes of vm to the DataVolumes restores, by volume name, and returns the DataVolumes
+// they used before. The DataVolumeTemplate of a previous DataVolume is replaced by one of its restore, which vm then
+// owns, so that KubeVirt neither recreates the previous DataVolume nor clones the restore a second time.
+func restoreVolumes(vm *v1.VirtualMachine, restores map[string]*cdiv1.DataVolume) ([]string, error) {
+	volumes := map[string]*v1.Volume{}
+	for i := range vm.Spec.Template.Spec.Volumes {
+		if volume := &vm.Spec.Template.Spec.Volumes[i]; volume.DataVolume != nil {
+			volumes[volume.Name] = volume
+		}
+	}
+	for volumeName := range restores {
+		if volumes[volumeName] == nil {
+			return nil, newAPIError(http.StatusConflict, ErrCodeConflict, "%s no longer has the volume %s.", vm.Name, volumeName)
+		}
+	}
+
+	var previous []string
+	for i := range vm.Spec.Template.Spec.Volumes {
+		volume := &vm.Spec.Template.Spec.Volumes[i]
+		dv := restores[volume.Name]
+		if dv == nil || volume.DataVolume == nil {
+			continue
+		}
+		if template := dataVolumeTemplate(vm, volume.DataVolume.Name); template != nil {
+			template.Name = dv.Name
+			template.Spec = dv.Spec
+			dv.OwnerReferences = []k8smetav1.OwnerReference{*k8smetav1.NewControllerRef(vm, v1.VirtualMachineGroupVersionKind)}
+		}
+		previous = append(previous, volume.DataVolume.Name)
+		volume.DataVolume.Name = dv.Name
+	}
+	return previous, nil
+}
+
+// newKubeVirtSnapshot returns the state of the KubeVirt snapshot, with its volumes taken from content if set.
+func newKubeVirtSnapshot(snapshot *snapshotv1.VirtualMachineSnapshot, content *snapshotv1.VirtualMachineSnapshotContent) models.Snapshot {
+	overview := models.Snapshot{
+		Name:         snapshot.Name,
+		Namespace:    snapshot.Namespace,
+		VM:           snapshot.Spec.Source.Name,
+		Method:       models.SnapshotMethodKubeVirt,
+		CreationTime: snapshot.CreationTimestamp.Time,
+	}
+	if status := snapshot.Status; status != nil {
+		overview.Ready = status.ReadyToUse != nil && *status.ReadyToUse
+		if status.Error != nil && status.Error.Message != nil {
+			overview.Error = *status.Error.Message
+		}
+	}
+	if content != nil {
+		for _, backup := range content.Spec.VolumeBackups {
+			overview.Volumes = append(overview.Volumes, backup.DiskName)
+		}
+	}
+	return overview
+}
+
+// newCloneSnapshots groups the DataVolumes of snapshots taken by cloning by snapshot.
+// A snapshot is ready once all its clones succeeded, it is as old as its oldest clone.
+func newCloneSnapshots(dataVolumes []cdiv1.DataVolume) []models.Snapshot {
+	var snapshots []models.Snapshot
+	index := map[string]int{}
+	for i := range dataVolumes {
+		dv := &dataVolumes[i]
+		name := dv.Labels[snapshotLabel]
+		n, ok := index[name]
+		if !ok {
+			n = len(snapshots)
+			index[name] = n
+			snapshots = append(snapshots, models.Snapshot{
+				Name:         name,
+				Namespace:    dv.Namespace,
+				VM:           dv.Labels[snapshotVMLabel],
+				Method:       models.SnapshotMethodClone,
+				Ready:        true,
+				CreationTime: dv.CreationTimestamp.Time,
+			})
+		}
+		snapshot := &snapshots[n]
+		volume := dv.Annotations[snapshotVolumeAnnotation]
+		snapshot.Volumes = append(snapshot.Volumes, volume)
+		snapshot.Ready = snapshot.Ready && dv.Status.Phase == cdiv1.Succeeded
+		if dv.Status.Phase == cdiv1.Failed && snapshot.Error == "" {
+			snapshot.Error = "Cloning volume " + volume + " failed."
+		}
+		if dv.CreationTimestamp.Time.Before(snapshot.CreationTime) {
+			snapshot.CreationTime = dv.CreationTimestamp.Time
+		}
+	}
+	return snapshots
+}
@@ -0,0 +1,163 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+	"time"
+	"virt-webui/models"
+
+	k8smetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1 "kubevirt.io/client-go/api/v1"
+	cdiv1 "kubevirt.io/containerized-data-importer/pkg/apis/core/v1alpha1"
+)
+
+func TestNewCloneSnapshots(t *testing.T) {
+	start := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+	newClone := func(snapshot, volume string, phase cdiv1.DataVolumePhase, created time.Time) cdiv1.DataVolume {
+		return cdiv1.DataVolume{
+			ObjectMeta: k8smetav1.ObjectMeta{
+				Name:              snapshot + "-" + volume,
+				Namespace:         "ns",
+				Labels:            map[string]string{snapshotLabel: snapshot, snapshotVMLabel: "vm"},
+				Annotations:       map[string]string{snapshotVolumeAnnotation: volume},
+				CreationTimestamp: k8smetav1.NewTime(created),
+			},
+			Status: cdiv1.DataVolumeStatus{Phase: phase},
+		}
+	}
+
+	got := newCloneSnapshots([]cdiv1.DataVolume{
+		newClone("before-upgrade", "rootdisk", cdiv1.Succeeded, start.Add(time.Second)),
+		newClone("before-upgrade", "datadisk", cdiv1.Succeeded, start),
+		newClone("nightly", "rootdisk", cdiv1.CloneInProgress, start.Add(time.Hour)),
+		newClone("nightly", "datadisk", cdiv1.Succeeded, start.Add(time.Hour)),
+		newClone("broken", "rootdisk", cdiv1.Failed, start.Add(2*time.Hour)),
+	})
+	expected := []models.Snapshot{
+		{Name: "before-upgrade", Namespace: "ns", VM: "vm", Method: models.SnapshotMethodClone, Ready: true,
+			Volumes: []string{"rootdisk", "datadisk"}, CreationTime: start},
+		{Name: "nightly", Namespace: "ns", VM: "vm", Method: models.SnapshotMethodClone,
+			Volumes: []string{"rootdisk", "datadisk"}, CreationTime: start.Add(time.Hour)},
+		{Name: "broken", Namespace: "ns", VM: "vm", Method: models.SnapshotMethodClone, Error: "Cloning volume rootdisk failed.",
+			Volumes: []string{"rootdisk"}, CreationTime: start.Add(2 * time.Hour)},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %+v, expected %+v", got, expected)
+	}
+}
+
+func TestRestoreVolumes(t *testing.T) {
+	newVM := func(templates ...string) *v1.VirtualMachine {
+		vm := &v1.VirtualMachine{
+			ObjectMeta: k8smetav1.ObjectMeta{Name: "vm", Namespace: "ns", UID: "1234"},
+			Spec: v1.VirtualMachineSpec{
+				Template: &v1.VirtualMachineInstanceTemplateSpec{
+					Spec: v1.VirtualMachineInstanceSpec{
+						Volumes: []v1.Volume{{
+							Name:         rootDiskName,
+							VolumeSource: v1.VolumeSource{DataVolume: &v1.DataVolumeSource{Name: "vm-dvdisk"}},
+						}, {
+							Name:         "data",
+							VolumeSource: v1.VolumeSource{DataVolume: &v1.DataVolumeSource{Name: "vm-data"}},
+						}},
+					},
+				},
+			},
+		}
+		for _, name := range templates {
+			vm.Spec.DataVolumeTemplates = append(vm.Spec.DataVolumeTemplates, cdiv1.DataVolume{
+				ObjectMeta: k8smetav1.ObjectMeta{Name: name},
+				Spec:       cdiv1.DataVolumeSpec{Source: cdiv1.DataVolumeSource{Blank: &cdiv1.DataVolumeBlankImage{}}},
+			})
+		}
+		return vm
+	}
+	newRestore := func(volume string) *cdiv1.DataVolume {
+		return &cdiv1.DataVolume{
+			ObjectMeta: k8smetav1.ObjectMeta{Name: "vm-" + volume + "-1", Namespace: "ns"},
+			Spec: cdiv1.DataVolumeSpec{
+				Source: cdiv1.DataVolumeSource{PVC: &cdiv1.DataVolumeSourcePVC{Namespace: "ns", Name: "snap-" + volume}},
+			},
+		}
+	}
+
+	for _, test := range []struct {
+		name      string
+		templates []string
+		volumes   []string
+		// expectedTemplates are the DataVolumeTemplates left, by name, with the restores cloning snapshots
+		expectedTemplates []string
+		expectedVolumes   []string
+		expectedPrevious  []string
+		expectedErr       bool
+	}{{
+		name:             "plain DataVolumes",
+		volumes:          []string{rootDiskName, "data"},
+		expectedVolumes:  []string{"vm-dvdisk-1", "vm-data-1"},
+		expectedPrevious: []string{"vm-dvdisk", "vm-data"},
+	}, {
+		name:              "DataVolumeTemplates",
+		templates:         []string{"vm-dvdisk", "vm-data"},
+		volumes:           []string{rootDiskName, "data"},
+		expectedTemplates: []string{"vm-dvdisk-1", "vm-data-1"},
+		expectedVolumes:   []string{"vm-dvdisk-1", "vm-data-1"},
+		expectedPrevious:  []string{"vm-dvdisk", "vm-data"},
+	}, {
+		name:              "some volumes from DataVolumeTemplates",
+		templates:         []string{"vm-dvdisk", "vm-data"},
+		volumes:           []string{rootDiskName},
+		expectedTemplates: []string{"vm-dvdisk-1", "vm-data"},
+		expectedVolumes:   []string{"vm-dvdisk-1", "vm-data"},
+		expectedPrevious:  []string{"vm-dvdisk"},
+	}, {
+		name:        "missing volume",
+		templates:   []string{"vm-dvdisk"},
+		volumes:     []string{rootDiskName, "scratch"},
+		expectedErr: true,
+	}} {
+		vm := newVM(test.templates...)
+		restores := map[string]*cdiv1.DataVolume{}
+		for _, volume := range test.volumes {
+			restores[volume] = newRestore(volume)
+		}
+		previous, err := restoreVolumes(vm, restores)
+		if test.expectedErr {
+			if err == nil || !reflect.DeepEqual(vm, newVM(test.templates...)) {
+				t.Errorf("%s: got %v and VM %+v, expected an error and the VM unchanged", test.name, err, vm.Spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(previous, test.expectedPrevious) {
+			t.Errorf("%s: got previous DataVolumes %v, expected %v", test.name, previous, test.expectedPrevious)
+		}
+		var volumes []string
+		for _, volume := range vm.Spec.Template.Spec.Volumes {
+			volumes = append(volumes, volume.DataVolume.Name)
+		}
+		if !reflect.DeepEqual(volumes, test.expectedVolumes) {
+			t.Errorf("%s: got volumes %v, expected %v", test.name, volumes, test.expectedVolumes)
+		}
+		var templates []string
+		for _, template := range vm.Spec.DataVolumeTemplates {
+			templates = append(templates, template.Name)
+		}
+		if !reflect.DeepEqual(templates, test.expectedTemplates) {
+			t.Errorf("%s: got DataVolumeTemplates %v, expected %v", test.name, templates, test.expectedTemplates)
+		}
+		for volume, restore := range restores {
+			template := dataVolumeTemplate(vm, restore.Name)
+			if template != nil && !reflect.DeepEqual(template.Spec, restore.Spec) {
+				t.Errorf("%s: got DataVolumeTemplate %+v, expected the spec of the restore of %s", test.name, template.Spec, volume)
+			}
+			owned := len(restore.OwnerReferences) == 1 && restore.OwnerReferences[0].UID == vm.UID &&
+				*restore.OwnerReferences[0].Controller
+			if owned != (template != nil) {
+				t.Errorf("%s: got owner references %+v on the restore of %s", test.name, restore.OwnerReferences, volume)
+			}
+		}
+	}
+}
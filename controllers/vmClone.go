@@ -0,0 +1,222 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"virt-webui/models"
+
+	k8sv1 "k8s.io/api/core/v1"
+	k8smetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1 "kubevirt.io/client-go/api/v1"
+	"kubevirt.io/client-go/kubecli"
+	cdiv1 "kubevirt.io/containerized-data-importer/pkg/apis/core/v1alpha1"
+)
+
+// @Title Clone VM
+// @Description Clone a stopped virtual machine into a new stopped VM with its own copy of each DataVolume. The disks are cloned in the cluster, get the new VM for the progress.
+// @Param	VMName	path	string	true	"The VM you want to clone"
+// @Param	body	body	controllers.JsonRequestCloneVM	true	"The new VM"
+// @Success 202 {object} controllers.JsonResponseCloneVMSuccess
+// @Failure 400 Bad clone request.
+// @Failure 403 Forbidden by the RBAC rules of the cluster.
+// @Failure 404 VM not found.
+// @Failure 409 VM not stopped, or new VM already exists.
+// @Failure 500 Failed to clone VM.
+// @router /:VMName/clone [post]
+func (v *VMController) Clone() {
+	namespace, virtClient, err := v.GetVirtClient()
+	if err != nil {
+		v.ResponseNotAvaliable(err)
+		return
+	}
+
+	vmName := v.Ctx.Input.Param(":VMName")
+	var jsonReq JsonRequestCloneVM
+	json.Unmarshal(v.Ctx.Input.RequestBody, &jsonReq)
+	newName := jsonReq.NewName
+	if newName == "" || newName == vmName {
+		v.SetError("Bad clone request.", newAPIError(http.StatusBadRequest, ErrCodeBadRequest, "NewName is required and must differ from the VM name."))
+		v.ServeJSON()
+		return
+	}
+
+	created, err := cloneVM(*virtClient, *namespace, vmName, newName)
+	if err == nil {
+		v.Ctx.Output.SetStatus(202)
+		v.Data["json"] = JsonResponseCloneVMSuccess{202, "Clone " + vmName + " to " + newName + " started.",
+			newVM(created, &vmStatusSources{allDataVolumes: true})}
+	} else {
+		v.SetError("Failed to clone "+vmName+" to "+newName+".", err)
+	}
+	v.ServeJSON()
+}
+
+type JsonRequestCloneVM struct {
+	NewName string
+}
+
+type JsonResponseCloneVMSuccess struct {
+	StatusCode int
+	Message    string
+	VM         models.VM
+}
+
+// cloneVM creates the VM newName as a clone of the stopped VM vmName. The cloud-init Secret created for vmName is copied.
+func cloneVM(client kubecli.KubevirtClient, namespace, vmName, newName string) (*v1.VirtualMachine, error) {
+	vm, err := client.VirtualMachine(namespace).Get(vmName, &k8smetav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if err := vmStopped(client, namespace, vmName); err != nil {
+		return nil, err
+	}
+
+	clones := map[string]*cdiv1.DataVolume{}
+	if vm.Spec.Template != nil {
+		for _, volume := range vm.Spec.Template.Spec.Volumes {
+			if volume.DataVolume == nil {
+				continue
+			}
+			dv, err := newCloneDataVolume(client, namespace, volume.DataVolume.Name, namespace, newName+"-"+volume.Name, "")
+			if err != nil {
+				return nil, wrapError(err, "cannot clone volume %s", volume.Name)
+			}
+			clones[volume.DataVolume.Name] = dv
+		}
+	}
+	clone := newVMClone(vm, newName, clones)
+
+	secretName := ""
+	if usesSecret(clone, cloudInitSecretName(newName)) {
+		secrets := client.CoreV1().Secrets(namespace)
+		secret, err := secrets.Get(cloudInitSecretName(vmName), k8smetav1.GetOptions{})
+		if err == nil {
+			_, err = secrets.Create(&k8sv1.Secret{
+				ObjectMeta: k8smetav1.ObjectMeta{Name: cloudInitSecretName(newName)},
+				Type:       secret.Type,
+				Data:       secret.Data,
+			})
+		}
+		if err != nil {
+			return nil, wrapError(err, "cannot copy the cloud-init data")
+		}
+		secretName = cloudInitSecretName(newName)
+	}
+
+	created, err := client.VirtualMachine(namespace).Create(clone)
+	if secretName != "" {
+		if err == nil {
+			ownSecret(client, namespace, secretName, k8smetav1.OwnerReference{
+				APIVersion: v1.GroupVersion.String(),
+				Kind:       "VirtualMachine",
+				Name:       created.Name,
+				UID:        created.UID,
+			})
+		} else {
+			client.CoreV1().Secrets(namespace).Delete(secretName, &k8smetav1.DeleteOptions{})
+		}
+	}
+	return created, err
+}
+
+// newVMClone returns the stopped VM newName with a deep copy of the spec of vm. Its DataVolumes are replaced by
+// DataVolumeTemplates with clones, which map the DataVolumes of vm to the ones cloning them, so the new VM owns
+// its disks. The labels and the cloud-init Secret naming vm are renamed, and the firmware UUID and the MAC addresses
+// are left for KubeVirt to generate.
+func newVMClone(vm *v1.VirtualMachine, newName string, clones map[string]*cdiv1.DataVolume) *v1.VirtualMachine {
+	running := false
+	clone := &v1.VirtualMachine{
+		ObjectMeta: k8smetav1.ObjectMeta{
+			Name:      newName,
+			Namespace: vm.Namespace,
+			Labels:    renameLabels(vm.Labels, vm.Name, newName),
+		},
+		Spec: *vm.Spec.DeepCopy(),
+	}
+	clone.Spec.Running = &running
+	clone.Spec.RunStrategy = nil
+	clone.Spec.DataVolumeTemplates = nil
+	if clone.Spec.Template == nil {
+		return clone
+	}
+
+	template := clone.Spec.Template
+	template.ObjectMeta.Labels = renameLabels(template.ObjectMeta.Labels, vm.Name, newName)
+	if template.Spec.Hostname == vm.Name {
+		template.Spec.Hostname = newName
+	}
+	if firmware := template.Spec.Domain.Firmware; firmware != nil {
+		firmware.UUID = ""
+		firmware.Serial = ""
+	}
+	for i := range template.Spec.Domain.Devices.Interfaces {
+		template.Spec.Domain.Devices.Interfaces[i].MacAddress = ""
+	}
+	for i := range template.Spec.Volumes {
+		volume := &template.Spec.Volumes[i]
+		if volume.DataVolume != nil {
+			if dv := clones[volume.DataVolume.Name]; dv != nil {
+				clone.Spec.DataVolumeTemplates = append(clone.Spec.DataVolumeTemplates, cdiv1.DataVolume{
+					ObjectMeta: k8smetav1.ObjectMeta{Name: dv.Name},
+					Spec:       dv.Spec,
+				})
+				volume.DataVolume.Name = dv.Name
+			}
+		}
+		for _, ref := range cloudInitSecretRefs(volume) {
+			if ref.Name == cloudInitSecretName(vm.Name) {
+				ref.Name = cloudInitSecretName(newName)
+			}
+		}
+	}
+	return clone
+}
+
+// renameLabels returns a copy of labels with the values naming the VM oldName, like the kubevirt.io/domain label,
+// naming newName instead.
+func renameLabels(labels map[string]string, oldName, newName string) map[string]string {
+	if labels == nil {
+		return nil
+	}
+	renamed := make(map[string]string, len(labels))
+	for key, value := range labels {
+		if value == oldName {
+			value = newName
+		}
+		renamed[key] = value
+	}
+	return renamed
+}
+
+// cloudInitSecretRefs returns the references to Secrets of the cloud-init data of volume.
+func cloudInitSecretRefs(volume *v1.Volume) []*k8sv1.LocalObjectReference {
+	var refs []*k8sv1.LocalObjectReference
+	if source := volume.CloudInitNoCloud; source != nil {
+		refs = append(refs, source.UserDataSecretRef, source.NetworkDataSecretRef)
+	}
+	if source := volume.CloudInitConfigDrive; source != nil {
+		refs = append(refs, source.UserDataSecretRef, source.NetworkDataSecretRef)
+	}
+	var set []*k8sv1.LocalObjectReference
+	for _, ref := range refs {
+		if ref != nil {
+			set = append(set, ref)
+		}
+	}
+	return set
+}
+
+// usesSecret tells whether the cloud-init data of vm is in the Secret name.
+func usesSecret(vm *v1.VirtualMachine, name string) bool {
+	if vm.Spec.Template == nil {
+		return false
+	}
+	for i := range vm.Spec.Template.Spec.Volumes {
+		for _, ref := range cloudInitSecretRefs(&vm.Spec.Template.Spec.Volumes[i]) {
+			if ref.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
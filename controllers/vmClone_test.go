@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	k8sv1 "k8s.io/api/core/v1"
+	k8smetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1 "kubevirt.io/client-go/api/v1"
+	cdiv1 "kubevirt.io/containerized-data-importer/pkg/apis/core/v1alpha1"
+)
+
+func TestNewVMClone(t *testing.T) {
+	running := true
+	vm := &v1.VirtualMachine{
+		ObjectMeta: k8smetav1.ObjectMeta{
+			Name:        "web",
+			Namespace:   "ns",
+			Labels:      map[string]string{flavorLabel: "small", "app": "web"},
+			Annotations: map[string]string{"kubevirt.io/latest-observed-api-version": "v1alpha3"},
+		},
+		Spec: v1.VirtualMachineSpec{
+			Running: &running,
+			Template: &v1.VirtualMachineInstanceTemplateSpec{
+				ObjectMeta: k8smetav1.ObjectMeta{
+					Labels: map[string]string{"kubevirt.io/domain": "web"},
+				},
+				Spec: v1.VirtualMachineInstanceSpec{
+					Hostname: "web",
+					Domain: v1.DomainSpec{
+						Firmware: &v1.Firmware{UUID: "5d307ca9-b3ef-428c-8861-06e72d69f223"},
+						Devices: v1.Devices{
+							Interfaces: []v1.Interface{{Name: "default", MacAddress: "02:00:00:00:00:01"}},
+						},
+					},
+					Volumes: []v1.Volume{{
+						Name:         "dvdisk",
+						VolumeSource: v1.VolumeSource{DataVolume: &v1.DataVolumeSource{Name: "ubuntu"}},
+					}, {
+						Name: cloudInitDiskName,
+						VolumeSource: v1.VolumeSource{CloudInitNoCloud: &v1.CloudInitNoCloudSource{
+							UserDataSecretRef: &k8sv1.LocalObjectReference{Name: cloudInitSecretName("web")},
+						}},
+					}},
+				},
+			},
+		},
+	}
+	clones := map[string]*cdiv1.DataVolume{
+		"ubuntu": {
+			ObjectMeta: k8smetav1.ObjectMeta{Name: "web2-dvdisk", Namespace: "ns"},
+			Spec: cdiv1.DataVolumeSpec{
+				Source: cdiv1.DataVolumeSource{PVC: &cdiv1.DataVolumeSourcePVC{Namespace: "ns", Name: "ubuntu"}},
+			},
+		},
+	}
+
+	clone := newVMClone(vm, "web2", clones)
+	if clone.Name != "web2" || clone.Namespace != "ns" || clone.Annotations != nil {
+		t.Errorf("got metadata %+v", clone.ObjectMeta)
+	}
+	if expected := map[string]string{flavorLabel: "small", "app": "web2"}; !reflect.DeepEqual(clone.Labels, expected) {
+		t.Errorf("got labels %v, expected %v", clone.Labels, expected)
+	}
+	if clone.Spec.Running == nil || *clone.Spec.Running {
+		t.Errorf("got a running clone")
+	}
+	template := clone.Spec.Template
+	if template.ObjectMeta.Labels["kubevirt.io/domain"] != "web2" || template.Spec.Hostname != "web2" {
+		t.Errorf("got domain label %s and hostname %s, expected web2", template.ObjectMeta.Labels["kubevirt.io/domain"], template.Spec.Hostname)
+	}
+	if template.Spec.Domain.Firmware.UUID != "" || template.Spec.Domain.Devices.Interfaces[0].MacAddress != "" {
+		t.Errorf("got firmware UUID %s and MAC address %s, expected generated ones",
+			template.Spec.Domain.Firmware.UUID, template.Spec.Domain.Devices.Interfaces[0].MacAddress)
+	}
+	if name := template.Spec.Volumes[0].DataVolume.Name; name != "web2-dvdisk" {
+		t.Errorf("got DataVolume %s, expected web2-dvdisk", name)
+	}
+	if len(clone.Spec.DataVolumeTemplates) != 1 || clone.Spec.DataVolumeTemplates[0].Name != "web2-dvdisk" ||
+		clone.Spec.DataVolumeTemplates[0].Spec.Source.PVC.Name != "ubuntu" {
+		t.Errorf("got DataVolumeTemplates %+v", clone.Spec.DataVolumeTemplates)
+	}
+	if !usesSecret(clone, cloudInitSecretName("web2")) {
+		t.Errorf("got cloud-init volume %+v, expected the secret of web2", template.Spec.Volumes[1])
+	}
+
+	// The source VM is left untouched
+	if vm.Spec.Template.Spec.Volumes[0].DataVolume.Name != "ubuntu" || vm.Spec.Template.ObjectMeta.Labels["kubevirt.io/domain"] != "web" ||
+		!*vm.Spec.Running || !usesSecret(vm, cloudInitSecretName("web")) {
+		t.Errorf("the source VM changed: %+v", vm.Spec)
+	}
+}
@@ -2,19 +2,23 @@ package controllers
 
 import (
 	"encoding/json"
-	"log"
+	"fmt"
+	"net/http"
 	"virt-webui/models"
 
 	"github.com/astaxie/beego"
 	k8sv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	k8smetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	v1 "kubevirt.io/client-go/api/v1"
+	"kubevirt.io/client-go/kubecli"
+	cdiv1 "kubevirt.io/containerized-data-importer/pkg/apis/core/v1alpha1"
 )
 
 // Operations about virtual machine
 type VMController struct {
-	beego.Controller
+	baseController
 }
 
 type JsonRequestVMName struct {
@@ -24,44 +28,35 @@ type JsonRequestVMName struct {
 // @Title List VM
 // @Description List all virtual machines.
 // @Success 200 {object} controllers.JsonResponseListVMSuccess
+// @Failure 403 Forbidden by the RBAC rules of the cluster.
 // @Failure 500 Failed to list VMs.
 // @router / [get]
 func (v *VMController) GetAll() {
-	ok, namespace, virtClient := GetVirtClient()
-	if !ok {
-		v.ResponseNotAvaliable()
+	namespace, virtClient, err := v.GetVirtClient()
+	if err != nil {
+		v.ResponseNotAvaliable(err)
 		return
 	}
 
 	// Fetch list of VMs
 	vmList, err := (*virtClient).VirtualMachine(*namespace).List(&k8smetav1.ListOptions{})
 	if err != nil {
-		log.Fatalf("cannot obtain KubeVirt vmi list: %v\n", err)
-		v.Ctx.Output.SetStatus(500)
-		v.Data["json"] = JsonResponseBasic{500, "Failed to list VMs. " + err.Error()}
+		v.SetError("Failed to list VMs.", err)
+		v.ServeJSON()
+		return
+	}
+
+	// Fetch the instances, launcher pods and DataVolumes of the VMs at once
+	sources, err := listVMStatusSources(*virtClient, *namespace)
+	if err != nil {
+		v.SetError("Failed to list VMs.", err)
 		v.ServeJSON()
 		return
 	}
 
 	var vms []models.VM
 	for _, vm := range vmList.Items {
-		var size int
-		var ready, ip string
-		if vm.Spec.Template.Spec.Domain.CPU.Cores == 1 {
-			size = 0
-		} else {
-			size = 1
-		}
-		if vm.Status.Ready {
-			ready = "Ready"
-			vmi, err := (*virtClient).VirtualMachineInstance(*namespace).Get(vm.Name, &k8smetav1.GetOptions{})
-			if err == nil && len(vmi.Status.Interfaces) > 0 {
-				ip = vmi.Status.Interfaces[0].IP
-			}
-		} else {
-			ready = "Not Ready"
-		}
-		vms = append(vms, models.VM{Name: vm.Name, Namespace: vm.Namespace, IP: ip, Size: size, Status: ready})
+		vms = append(vms, newVM(&vm, sources))
 	}
 	v.Data["json"] = JsonResponseListVMSuccess{200, "VMs list success.", vms}
 	v.ServeJSON()
@@ -80,9 +75,9 @@ type JsonResponseListVMSuccess struct {
 // @Failure 500 Failed to get VM.
 // @router /:VMName [get]
 func (v *VMController) Get() {
-	ok, namespace, virtClient := GetVirtClient()
-	if !ok {
-		v.ResponseNotAvaliable()
+	namespace, virtClient, err := v.GetVirtClient()
+	if err != nil {
+		v.ResponseNotAvaliable(err)
 		return
 	}
 
@@ -90,41 +85,36 @@ func (v *VMController) Get() {
 	vm, err := (*virtClient).VirtualMachine(*namespace).Get(vmName, &k8smetav1.GetOptions{})
 
 	if err == nil {
-		var size int
-		var ready, ip, img string
-		if vm.Spec.Template.Spec.Domain.CPU.Cores == 1 {
-			size = 0
-		} else {
-			size = 1
+		var img string
+		sources := getVMStatusSources(*virtClient, vm)
+		if vmi := sources.instances[vm.Name]; vmi != nil && len(vmi.Spec.Volumes) > 0 && vmi.Spec.Volumes[0].DataVolume != nil {
+			img = vmi.Spec.Volumes[0].DataVolume.Name
 		}
-		if vm.Status.Ready {
-			ready = "Ready"
-			vmi, err := (*virtClient).VirtualMachineInstance(*namespace).Get(vm.Name, &k8smetav1.GetOptions{})
-			if err == nil {
-				if len(vmi.Status.Interfaces) > 0 {
-					ip = vmi.Status.Interfaces[0].IP
-				}
-				if len(vmi.Spec.Volumes) > 0 {
-					img = vmi.Spec.Volumes[0].DataVolume.Name
-				}
-			}
-		} else {
-			ready = "Not Ready"
+		// A root disk cloned from an image reports the image
+		if template := dataVolumeTemplate(vm, img); template != nil && template.Spec.Source.PVC != nil {
+			img = template.Spec.Source.PVC.Name
 		}
+		overview := newVM(vm, sources)
 		v.Data["json"] = JsonResponseGetVMSuccess{
-			StatusCode: 200,
-			Message:    vmName + " get success.",
-			VM:         *vm,
-			Name:       vmName,
-			Namespace:  *namespace,
-			Image:      img,
-			Size:       size,
-			Status:     ready,
-			IP:         ip,
+			StatusCode:    200,
+			Message:       vmName + " get success.",
+			VM:            *vm,
+			Name:          vmName,
+			Namespace:     *namespace,
+			Image:         img,
+			Flavor:        overview.Flavor,
+			Cores:         overview.Cores,
+			Sockets:       overview.Sockets,
+			Threads:       overview.Threads,
+			Memory:        overview.Memory,
+			Status:        overview.Status,
+			StatusReason:  overview.StatusReason,
+			StatusMessage: overview.StatusMessage,
+			IP:            overview.IP,
+			Node:          overview.Node,
 		}
 	} else {
-		v.Ctx.Output.SetStatus(500)
-		v.Data["json"] = JsonResponseBasic{500, "Failed to get " + vmName + ". " + err.Error()}
+		v.SetError("Failed to get "+vmName+".", err)
 	}
 	v.ServeJSON()
 }
@@ -135,76 +125,198 @@ type JsonResponseGetVMSuccess struct {
 	Name       string
 	Namespace  string
 	Image      string
-	Size       int
+	Flavor     string
+	Cores      uint32
+	Sockets    uint32
+	Threads    uint32
+	Memory     string
 	Status     string
-	IP         string
-	VM         v1.VirtualMachine
+	// StatusReason and StatusMessage explain a Status other than Running or Stopped, when known
+	StatusReason  string `json:",omitempty"`
+	StatusMessage string `json:",omitempty"`
+	IP            string
+	Node          string
+	VM            v1.VirtualMachine
+}
+
+// flavorLabel is the label on a VM naming the flavor it was created from.
+const flavorLabel = "virt-webui.io/flavor"
+
+// newVM returns the overview of vm, with the status derived from sources.
+func newVM(vm *v1.VirtualMachine, sources *vmStatusSources) models.VM {
+	status := sources.status(vm)
+	overview := models.VM{
+		Name:          vm.Name,
+		Namespace:     vm.Namespace,
+		Flavor:        vm.Labels[flavorLabel],
+		Status:        status.status,
+		StatusReason:  status.reason,
+		StatusMessage: status.message,
+	}
+	if vmi := sources.instances[vm.Name]; vmi != nil {
+		overview.Node = vmi.Status.NodeName
+		if len(vmi.Status.Interfaces) > 0 {
+			overview.IP = vmi.Status.Interfaces[0].IP
+		}
+	}
+	if vm.Spec.Template == nil {
+		return overview
+	}
+	domain := vm.Spec.Template.Spec.Domain
+	if domain.CPU != nil {
+		overview.Cores = domain.CPU.Cores
+		overview.Sockets = domain.CPU.Sockets
+		overview.Threads = domain.CPU.Threads
+	}
+	if memory, ok := domain.Resources.Requests[k8sv1.ResourceMemory]; ok {
+		overview.Memory = memory.String()
+	}
+	return overview
 }
 
 // @Title Start VM
 // @Description Start an exist virtual machine.
 // @Param	body	body	controllers.JsonRequestVMName	true	"The vm you want to start"
 // @Success 200 {object} controllers.JsonResponseBasic
+// @Failure 403 Forbidden by the RBAC rules of the cluster.
 // @Failure 500 Failed to start VM.
 // @router /start [POST]
 func (v *VMController) Start() {
-	ok, namespace, virtClient := GetVirtClient()
-	if !ok {
-		v.ResponseNotAvaliable()
-		return
-	}
-
-	var jsonReq JsonRequestVMName
-	json.Unmarshal(v.Ctx.Input.RequestBody, &jsonReq)
-	vmName := jsonReq.Name
-
-	err := (*virtClient).VirtualMachine(*namespace).Start(vmName)
-	if err == nil {
-		v.Data["json"] = JsonResponseBasic{200, vmName + " start success."}
-	} else {
-		v.Ctx.Output.SetStatus(500)
-		v.Data["json"] = JsonResponseBasic{500, "Failed to start " + vmName + ". " + err.Error()}
-	}
-	v.ServeJSON()
+	v.vmAction("start", func(virtClient kubecli.KubevirtClient, namespace, vmName string) error {
+		return virtClient.VirtualMachine(namespace).Start(vmName)
+	})
 }
 
 // @Title Stop VM
 // @Description Stop an exist virtual machine.
 // @Param	body	body	controllers.JsonRequestVMName	true	"The vm you want to stop"
 // @Success 200 {object} controllers.JsonResponseBasic
+// @Failure 403 Forbidden by the RBAC rules of the cluster.
 // @Failure 500 Failed to stop VM.
 // @router /stop [POST]
 func (v *VMController) Stop() {
-	ok, namespace, virtClient := GetVirtClient()
-	if !ok {
-		v.ResponseNotAvaliable()
+	v.vmAction("stop", func(virtClient kubecli.KubevirtClient, namespace, vmName string) error {
+		return virtClient.VirtualMachine(namespace).Stop(vmName)
+	})
+}
+
+// @Title Restart VM
+// @Description Restart a running virtual machine by stopping its instance and starting a new one.
+// @Param	body	body	controllers.JsonRequestVMName	true	"The vm you want to restart"
+// @Success 200 {object} controllers.JsonResponseBasic
+// @Failure 403 Forbidden by the RBAC rules of the cluster.
+// @Failure 404 VM not found.
+// @Failure 409 VM not running.
+// @Failure 500 Failed to restart VM.
+// @router /restart [POST]
+func (v *VMController) Restart() {
+	v.vmAction("restart", func(virtClient kubecli.KubevirtClient, namespace, vmName string) error {
+		return virtClient.VirtualMachine(namespace).Restart(vmName)
+	})
+}
+
+// @Title Pause VM
+// @Description Pause a running virtual machine, freezing its guest in memory.
+// @Param	body	body	controllers.JsonRequestVMName	true	"The vm you want to pause"
+// @Success 200 {object} controllers.JsonResponseBasic
+// @Failure 403 Forbidden by the RBAC rules of the cluster.
+// @Failure 404 VM not running.
+// @Failure 409 VM already paused.
+// @Failure 500 Failed to pause VM.
+// @router /pause [POST]
+func (v *VMController) Pause() {
+	v.vmAction("pause", func(virtClient kubecli.KubevirtClient, namespace, vmName string) error {
+		return virtClient.VirtualMachineInstance(namespace).Pause(vmName)
+	})
+}
+
+// @Title Unpause VM
+// @Description Resume a paused virtual machine.
+// @Param	body	body	controllers.JsonRequestVMName	true	"The vm you want to unpause"
+// @Success 200 {object} controllers.JsonResponseBasic
+// @Failure 403 Forbidden by the RBAC rules of the cluster.
+// @Failure 404 VM not running.
+// @Failure 409 VM not paused.
+// @Failure 500 Failed to unpause VM.
+// @router /unpause [POST]
+func (v *VMController) Unpause() {
+	v.vmAction("unpause", func(virtClient kubecli.KubevirtClient, namespace, vmName string) error {
+		return virtClient.VirtualMachineInstance(namespace).Unpause(vmName)
+	})
+}
+
+// @Title Soft Reboot VM
+// @Description Reboot the guest OS of a running virtual machine through its guest agent, keeping its instance.
+// @Param	body	body	controllers.JsonRequestVMName	true	"The vm you want to reboot"
+// @Success 200 {object} controllers.JsonResponseBasic
+// @Failure 403 Forbidden by the RBAC rules of the cluster.
+// @Failure 404 VM not running.
+// @Failure 409 Guest agent not connected.
+// @Failure 501 Soft reboot not supported by the KubeVirt of the cluster.
+// @Failure 500 Failed to soft reboot VM.
+// @router /softreboot [POST]
+func (v *VMController) SoftReboot() {
+	v.vmAction("soft reboot", softReboot)
+}
+
+// vmAction runs action on the VM named by the request body and reports the outcome.
+// verb names the action in the response message.
+func (v *VMController) vmAction(verb string, action func(virtClient kubecli.KubevirtClient, namespace, vmName string) error) {
+	namespace, virtClient, err := v.GetVirtClient()
+	if err != nil {
+		v.ResponseNotAvaliable(err)
 		return
 	}
 
 	var jsonReq JsonRequestVMName
 	json.Unmarshal(v.Ctx.Input.RequestBody, &jsonReq)
 	vmName := jsonReq.Name
+	if vmName == "" {
+		v.SetError("Bad "+verb+" request.", newAPIError(http.StatusBadRequest, ErrCodeBadRequest, "The VM name is required."))
+		v.ServeJSON()
+		return
+	}
 
-	err := (*virtClient).VirtualMachine(*namespace).Stop(vmName)
+	err = action(*virtClient, *namespace, vmName)
 	if err == nil {
-		v.Data["json"] = JsonResponseBasic{200, vmName + " stop success."}
+		v.Data["json"] = JsonResponseBasic{StatusCode: 200, Message: vmName + " " + verb + " success."}
 	} else {
-		v.Ctx.Output.SetStatus(500)
-		v.Data["json"] = JsonResponseBasic{500, "Failed to stop " + vmName + ". " + err.Error()}
+		v.SetError("Failed to "+verb+" "+vmName+".", err)
 	}
 	v.ServeJSON()
 }
 
+// softReboot asks the guest agent of the instance of the VM to reboot the guest OS.
+// The softreboot subresource is only served by recent KubeVirt releases.
+func softReboot(virtClient kubecli.KubevirtClient, namespace, vmName string) error {
+	vmi, err := virtClient.VirtualMachineInstance(namespace).Get(vmName, &k8smetav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if condition := vmiCondition(vmi, v1.VirtualMachineInstanceAgentConnected); condition == nil || condition.Status != k8sv1.ConditionTrue {
+		return newAPIError(http.StatusConflict, ErrCodeConflict, "The guest agent of %s is not connected.", vmName)
+	}
+	uri := fmt.Sprintf("/apis/subresources.kubevirt.io/%s/namespaces/%s/virtualmachineinstances/%s/softreboot",
+		v1.ApiStorageVersion, namespace, vmName)
+	err = virtClient.RestClient().Put().RequestURI(uri).Do().Error()
+	if k8serrors.IsNotFound(err) {
+		return newAPIError(http.StatusNotImplemented, ErrCodeNotImplemented, "The KubeVirt of the cluster does not support soft reboot.")
+	}
+	return err
+}
+
 // @Title Create VM
-// @Description Create a new virtual machines.
+// @Description Create a new virtual machines. With DiskMode Clone the VM gets its own root disk cloned from the image, which stays untouched.
 // @Param	body	body	controllers.JsonRequestCreateVM	true	"The VM content"
 // @Success 200 {object} controllers.JsonResponseCreateVM
+// @Failure 400 Bad create request.
+// @Failure 403 Forbidden by the RBAC rules of the cluster.
 // @Failure 500 Failed to create VM.
 // @router / [POST]
 func (v *VMController) Create() {
-	ok, namespace, virtClient := GetVirtClient()
-	if !ok {
-		v.ResponseNotAvaliable()
+	namespace, virtClient, err := v.GetVirtClient()
+	if err != nil {
+		v.ResponseNotAvaliable(err)
 		return
 	}
 
@@ -212,16 +324,28 @@ func (v *VMController) Create() {
 	json.Unmarshal(v.Ctx.Input.RequestBody, &jsonReq)
 	vmName := jsonReq.Name
 	image := jsonReq.Image
-	size := jsonReq.Size
 	running := false
-	var cpu uint32
-	var memory string
-	if size == 0 {
-		cpu = 1
-		memory = "1G"
-	} else {
-		cpu = 2
-		memory = "2G"
+
+	flavor, err := resolveFlavor(&jsonReq)
+	if err == nil && jsonReq.CloudInit != nil {
+		err = validateCloudInit(jsonReq.CloudInit)
+	}
+	if jsonReq.DiskMode == "" {
+		jsonReq.DiskMode = beego.AppConfig.DefaultString("vmdiskmode", VMDiskModeAttach)
+	}
+	diskMode := jsonReq.DiskMode
+	if err == nil && diskMode != VMDiskModeAttach && diskMode != VMDiskModeClone {
+		err = fmt.Errorf("unknown DiskMode %s", diskMode)
+	} else if err == nil && diskMode == VMDiskModeAttach && jsonReq.DiskSize != "" {
+		err = fmt.Errorf("DiskSize needs DiskMode %s, an attached image keeps its size", VMDiskModeClone)
+	}
+	if diskMode == VMDiskModeClone && jsonReq.DiskSize == "" {
+		jsonReq.DiskSize = beego.AppConfig.String("vmdisksize")
+	}
+	if err != nil {
+		v.SetError("Bad create request.", badRequest(err))
+		v.ServeJSON()
+		return
 	}
 
 	vm := v1.VirtualMachine{
@@ -243,12 +367,14 @@ func (v *VMController) Create() {
 				Spec: v1.VirtualMachineInstanceSpec{
 					Domain: v1.DomainSpec{
 						CPU: &v1.CPU{
-							Cores: cpu,
+							Cores:   flavor.Cores,
+							Sockets: flavor.Sockets,
+							Threads: flavor.Threads,
 						},
 						Devices: v1.Devices{
 							Disks: []v1.Disk{
 								{
-									Name: "dvdisk",
+									Name: rootDiskName,
 									DiskDevice: v1.DiskDevice{
 										Disk: &v1.DiskTarget{
 											Bus: "virtio",
@@ -259,13 +385,13 @@ func (v *VMController) Create() {
 						},
 						Resources: v1.ResourceRequirements{
 							Requests: k8sv1.ResourceList{
-								"memory": resource.MustParse(memory),
+								"memory": resource.MustParse(flavor.Memory),
 							},
 						},
 					},
 					Volumes: []v1.Volume{
 						{
-							Name: "dvdisk",
+							Name: rootDiskName,
 							VolumeSource: v1.VolumeSource{
 								DataVolume: &v1.DataVolumeSource{
 									image,
@@ -278,14 +404,47 @@ func (v *VMController) Create() {
 		},
 	}
 
-	_, err := (*virtClient).VirtualMachine(*namespace).Create(&vm)
+	applyFlavor(&vm, flavor)
+
+	if diskMode == VMDiskModeClone {
+		err = addRootDiskClone(*virtClient, *namespace, &vm, image, jsonReq.DiskSize)
+		if err != nil {
+			v.SetError("Failed to create "+vmName+".", err)
+			v.ServeJSON()
+			return
+		}
+	}
+
+	cloudInitSecret := ""
+	if jsonReq.CloudInit != nil {
+		cloudInitSecret, err = addCloudInit(*virtClient, *namespace, &vm, jsonReq.CloudInit)
+		if err != nil {
+			v.SetError("Failed to create "+vmName+".", err)
+			v.ServeJSON()
+			return
+		}
+	}
+
+	created, err := (*virtClient).VirtualMachine(*namespace).Create(&vm)
+	if cloudInitSecret != "" {
+		if err == nil {
+			ownSecret(*virtClient, *namespace, cloudInitSecret, k8smetav1.OwnerReference{
+				APIVersion: v1.GroupVersion.String(),
+				Kind:       "VirtualMachine",
+				Name:       created.Name,
+				UID:        created.UID,
+			})
+		} else {
+			(*virtClient).CoreV1().Secrets(*namespace).Delete(cloudInitSecret, &k8smetav1.DeleteOptions{})
+		}
+	}
 
 	if err == nil {
-		v.Data["json"] = JsonResponseCreateVM{200, vmName + " create success.",
-			JsonRequestCreateVM{vmName, image, size}}
+		// The user data may hold credentials, so it is not echoed back.
+		jsonReq.CloudInit = nil
+		v.Data["json"] = JsonResponseCreateVM{200, vmName + " create success.", jsonReq}
 	} else {
-		v.Ctx.Output.SetStatus(500)
-		v.Data["json"] = JsonResponseBasic{500, "Failed to create " + vmName + ". " + err.Error()}
+		v.SetError("Failed to create "+vmName+".", err)
 	}
 	v.ServeJSON()
 }
@@ -293,7 +452,107 @@ func (v *VMController) Create() {
 type JsonRequestCreateVM struct {
 	Name  string
 	Image string
-	Size  int
+	// DiskMode is "Attach" to use the image itself as the root disk, or "Clone" to give the VM
+	// its own clone of the image. The vmdiskmode of app.conf is used if empty.
+	DiskMode string
+	// DiskSize of the cloned root disk, at least the size of the image. The vmdisksize of
+	// app.conf is used if empty, the size of the image if that is empty too.
+	DiskSize string
+	// Flavor of the VM. Without a flavor Cores and Memory must be set, without
+	// either the defaultflavor of app.conf is used.
+	Flavor  string
+	Cores   uint32
+	Sockets uint32
+	Threads uint32
+	Memory  string
+	// CloudInit optionally configures the guest on first boot
+	CloudInit *JsonCloudInit
+}
+
+// resolveFlavor returns the flavor requested by jsonReq, either by name or as explicit CPU
+// and memory, and updates jsonReq to describe it in full.
+func resolveFlavor(jsonReq *JsonRequestCreateVM) (models.Flavor, error) {
+	if jsonReq.Flavor == "" && jsonReq.Cores == 0 && jsonReq.Memory == "" {
+		jsonReq.Flavor = beego.AppConfig.String("defaultflavor")
+	}
+
+	var flavor models.Flavor
+	if jsonReq.Flavor != "" {
+		var ok bool
+		flavor, ok = getFlavor(jsonReq.Flavor)
+		if !ok {
+			return flavor, fmt.Errorf("unknown flavor %s", jsonReq.Flavor)
+		}
+	} else {
+		flavor = models.Flavor{
+			Cores:   jsonReq.Cores,
+			Sockets: jsonReq.Sockets,
+			Threads: jsonReq.Threads,
+			Memory:  jsonReq.Memory,
+		}
+		if err := validateFlavor(flavor); err != nil {
+			return flavor, err
+		}
+	}
+
+	jsonReq.Cores, jsonReq.Sockets, jsonReq.Threads = flavor.Cores, flavor.Sockets, flavor.Threads
+	jsonReq.Memory = flavor.Memory
+	return flavor, nil
+}
+
+// applyFlavor sets the limits, hugepages and flavor label of flavor on vm.
+func applyFlavor(vm *v1.VirtualMachine, flavor models.Flavor) {
+	if flavor.Name != "" {
+		if vm.Labels == nil {
+			vm.Labels = map[string]string{}
+		}
+		vm.Labels[flavorLabel] = flavor.Name
+	}
+
+	domain := &vm.Spec.Template.Spec.Domain
+	if flavor.CPULimit != "" || flavor.MemoryLimit != "" {
+		domain.Resources.Limits = k8sv1.ResourceList{}
+	}
+	if flavor.CPULimit != "" {
+		domain.Resources.Limits[k8sv1.ResourceCPU] = resource.MustParse(flavor.CPULimit)
+	}
+	if flavor.MemoryLimit != "" {
+		domain.Resources.Limits[k8sv1.ResourceMemory] = resource.MustParse(flavor.MemoryLimit)
+	}
+	if flavor.HugepagesPageSize != "" {
+		domain.Memory = &v1.Memory{
+			Hugepages: &v1.Hugepages{PageSize: flavor.HugepagesPageSize},
+		}
+	}
+}
+
+// rootDiskName is the disk of a new VM its image is attached or cloned to.
+const rootDiskName = "dvdisk"
+
+// The ways the root disk of a new VM is made from its image
+const (
+	VMDiskModeAttach = "Attach"
+	VMDiskModeClone  = "Clone"
+)
+
+// addRootDiskClone replaces the image volumes of vm by a DataVolumeTemplate cloning the image, so vm
+// owns a private root disk of size, or the size of the image if empty.
+func addRootDiskClone(client kubecli.KubevirtClient, namespace string, vm *v1.VirtualMachine, image, size string) error {
+	name := vm.Name + "-" + rootDiskName
+	dv, err := newCloneDataVolume(client, namespace, image, namespace, name, size)
+	if err != nil {
+		return wrapError(err, "cannot clone image %s", image)
+	}
+	vm.Spec.DataVolumeTemplates = append(vm.Spec.DataVolumeTemplates, cdiv1.DataVolume{
+		ObjectMeta: k8smetav1.ObjectMeta{Name: name},
+		Spec:       dv.Spec,
+	})
+	for i := range vm.Spec.Template.Spec.Volumes {
+		if volume := &vm.Spec.Template.Spec.Volumes[i]; volume.DataVolume != nil && volume.DataVolume.Name == image {
+			volume.DataVolume.Name = name
+		}
+	}
+	return nil
 }
 
 type JsonResponseCreateVM struct {
@@ -310,9 +569,9 @@ type JsonResponseCreateVM struct {
 // @Failure 500 Failed to rename VM.
 // @router /:VMName [put]
 func (v *VMController) Put() {
-	ok, namespace, virtClient := GetVirtClient()
-	if !ok {
-		v.ResponseNotAvaliable()
+	namespace, virtClient, err := v.GetVirtClient()
+	if err != nil {
+		v.ResponseNotAvaliable(err)
 		return
 	}
 
@@ -321,12 +580,11 @@ func (v *VMController) Put() {
 	json.Unmarshal(v.Ctx.Input.RequestBody, &jsonReq)
 	newName := jsonReq.NewName
 
-	err := (*virtClient).VirtualMachine(*namespace).Rename(vmName, &v1.RenameOptions{NewName: newName})
+	err = (*virtClient).VirtualMachine(*namespace).Rename(vmName, &v1.RenameOptions{NewName: newName})
 	if err == nil {
 		v.Data["json"] = JsonResponseRenameSuccess{200, "Rename " + vmName + " to " + newName + " success.", newName}
 	} else {
-		v.Ctx.Output.SetStatus(500)
-		v.Data["json"] = JsonResponseBasic{500, "Failed to rename " + vmName + " to " + newName + ". " + err.Error()}
+		v.SetError("Failed to rename "+vmName+" to "+newName+".", err)
 	}
 	v.ServeJSON()
 }
@@ -335,24 +593,24 @@ func (v *VMController) Put() {
 // @Description Delete an exist virtual machine.
 // @Param	VMName	path	string	true	"The VM you want to delete"
 // @Success 200 {object} controllers.JsonResponseBasic
+// @Failure 403 Forbidden by the RBAC rules of the cluster.
 // @Failure 500 Failed to delete VM.
 // @router /:VMName [delete]
 func (v *VMController) Delete() {
-	ok, namespace, virtClient := GetVirtClient()
-	if !ok {
-		v.ResponseNotAvaliable()
+	namespace, virtClient, err := v.GetVirtClient()
+	if err != nil {
+		v.ResponseNotAvaliable(err)
 		return
 	}
 
 	vmName := v.Ctx.Input.Param(":VMName")
 
-	err := (*virtClient).VirtualMachine(*namespace).Delete(vmName, &k8smetav1.DeleteOptions{})
+	err = (*virtClient).VirtualMachine(*namespace).Delete(vmName, &k8smetav1.DeleteOptions{})
 
 	if err == nil {
-		v.Data["json"] = JsonResponseBasic{200, vmName + " delete success."}
+		v.Data["json"] = JsonResponseBasic{StatusCode: 200, Message: vmName + " delete success."}
 	} else {
-		v.Ctx.Output.SetStatus(500)
-		v.Data["json"] = JsonResponseBasic{500, "Failed to delete " + vmName + ". " + err.Error()}
+		v.SetError("Failed to delete "+vmName+".", err)
 	}
 	v.ServeJSON()
 }
@@ -0,0 +1,111 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	k8sv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	k8smetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	v1 "kubevirt.io/client-go/api/v1"
+	cdiclient "kubevirt.io/client-go/generated/containerized-data-importer/clientset/versioned"
+	cdifake "kubevirt.io/client-go/generated/containerized-data-importer/clientset/versioned/fake"
+	"kubevirt.io/client-go/kubecli"
+	cdiv1 "kubevirt.io/containerized-data-importer/pkg/apis/core/v1alpha1"
+)
+
+// fakeVirtClient serves the core and CDI APIs from fake clientsets, the rest of KubevirtClient is not implemented.
+type fakeVirtClient struct {
+	kubecli.KubevirtClient
+	k8s *k8sfake.Clientset
+	cdi *cdifake.Clientset
+}
+
+func (c fakeVirtClient) CoreV1() corev1.CoreV1Interface {
+	return c.k8s.CoreV1()
+}
+
+func (c fakeVirtClient) CdiClient() cdiclient.Interface {
+	return c.cdi
+}
+
+func TestAddRootDiskClone(t *testing.T) {
+	storageClass := "fast"
+	client := fakeVirtClient{k8s: k8sfake.NewSimpleClientset(&k8sv1.PersistentVolumeClaim{
+		ObjectMeta: k8smetav1.ObjectMeta{Name: "ubuntu", Namespace: "ns"},
+		Spec: k8sv1.PersistentVolumeClaimSpec{
+			AccessModes:      []k8sv1.PersistentVolumeAccessMode{k8sv1.ReadWriteMany},
+			StorageClassName: &storageClass,
+			Resources: k8sv1.ResourceRequirements{
+				Requests: k8sv1.ResourceList{k8sv1.ResourceStorage: resource.MustParse("5Gi")},
+			},
+		},
+	})}
+	newVM := func() *v1.VirtualMachine {
+		return &v1.VirtualMachine{
+			ObjectMeta: k8smetav1.ObjectMeta{Name: "web", Namespace: "ns"},
+			Spec: v1.VirtualMachineSpec{
+				Template: &v1.VirtualMachineInstanceTemplateSpec{
+					Spec: v1.VirtualMachineInstanceSpec{
+						Volumes: []v1.Volume{{
+							Name:         rootDiskName,
+							VolumeSource: v1.VolumeSource{DataVolume: &v1.DataVolumeSource{Name: "ubuntu"}},
+						}, {
+							Name:         "tools",
+							VolumeSource: v1.VolumeSource{DataVolume: &v1.DataVolumeSource{Name: "tools-iso"}},
+						}},
+					},
+				},
+			},
+		}
+	}
+
+	for _, test := range []struct {
+		image, size  string
+		expectedSize string
+		expectedErr  bool
+	}{
+		{image: "ubuntu", expectedSize: "5Gi"},
+		{image: "ubuntu", size: "20Gi", expectedSize: "20Gi"},
+		{image: "ubuntu", size: "1Gi", expectedErr: true},
+		{image: "ubuntu", size: "large", expectedErr: true},
+		{image: "centos", expectedErr: true},
+	} {
+		vm := newVM()
+		err := addRootDiskClone(client, "ns", vm, test.image, test.size)
+		if test.expectedErr {
+			if err == nil || !reflect.DeepEqual(vm, newVM()) {
+				t.Errorf("%s of size %q: got %v and VM %+v, expected an error and the VM unchanged", test.image, test.size, err, vm.Spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s of size %q: %v", test.image, test.size, err)
+			continue
+		}
+
+		volumes := vm.Spec.Template.Spec.Volumes
+		if volumes[0].DataVolume.Name != "web-"+rootDiskName || volumes[1].DataVolume.Name != "tools-iso" {
+			t.Errorf("got volumes %+v, expected the root disk on web-%s and the others unchanged", volumes, rootDiskName)
+		}
+		if len(vm.Spec.DataVolumeTemplates) != 1 {
+			t.Fatalf("got DataVolumeTemplates %+v, expected one", vm.Spec.DataVolumeTemplates)
+		}
+		template := vm.Spec.DataVolumeTemplates[0]
+		expected := cdiv1.DataVolumeSpec{
+			Source: cdiv1.DataVolumeSource{PVC: &cdiv1.DataVolumeSourcePVC{Namespace: "ns", Name: "ubuntu"}},
+			PVC: &k8sv1.PersistentVolumeClaimSpec{
+				AccessModes:      []k8sv1.PersistentVolumeAccessMode{k8sv1.ReadWriteMany},
+				StorageClassName: &storageClass,
+				Resources: k8sv1.ResourceRequirements{
+					Requests: k8sv1.ResourceList{k8sv1.ResourceStorage: resource.MustParse(test.expectedSize)},
+				},
+			},
+		}
+		if template.Name != "web-"+rootDiskName || template.Namespace != "" || !reflect.DeepEqual(template.Spec, expected) {
+			t.Errorf("got DataVolumeTemplate %s with %+v, expected web-%s with %+v", template.Name, template.Spec, rootDiskName, expected)
+		}
+	}
+}
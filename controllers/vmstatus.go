@@ -0,0 +1,259 @@
+package controllers
+
+import (
+	"fmt"
+	"virt-webui/models"
+
+	k8sv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	k8smetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	v1 "kubevirt.io/client-go/api/v1"
+	"kubevirt.io/client-go/kubecli"
+	cdiv1 "kubevirt.io/containerized-data-importer/pkg/apis/core/v1alpha1"
+)
+
+// vmStatusSources holds the objects besides the VMs their statuses are derived from.
+// The pods and DataVolumes are optional, the statuses are less precise without them.
+type vmStatusSources struct {
+	instances map[string]*v1.VirtualMachineInstance
+	// pods holds the newest virt-launcher pod of each instance by the UID of the instance
+	pods        map[types.UID]*k8sv1.Pod
+	dataVolumes map[string]*cdiv1.DataVolume
+	// allDataVolumes tells whether dataVolumes holds all the DataVolumes of the namespace
+	allDataVolumes bool
+}
+
+func newVMStatusSources() *vmStatusSources {
+	return &vmStatusSources{
+		instances:   map[string]*v1.VirtualMachineInstance{},
+		pods:        map[types.UID]*k8sv1.Pod{},
+		dataVolumes: map[string]*cdiv1.DataVolume{},
+	}
+}
+
+// listVMStatusSources fetches the status sources of all the VMs of namespace at once.
+// Only failing to list the instances is an error, the pods and DataVolumes may not be
+// readable by the user.
+func listVMStatusSources(client kubecli.KubevirtClient, namespace string) (*vmStatusSources, error) {
+	sources := newVMStatusSources()
+	vmiList, err := client.VirtualMachineInstance(namespace).List(&k8smetav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for i := range vmiList.Items {
+		sources.instances[vmiList.Items[i].Name] = &vmiList.Items[i]
+	}
+	podList, err := client.CoreV1().Pods(namespace).List(k8smetav1.ListOptions{LabelSelector: v1.AppLabel + "=virt-launcher"})
+	if err == nil {
+		sources.addPods(podList.Items)
+	}
+	dvList, err := client.CdiClient().CdiV1alpha1().DataVolumes(namespace).List(k8smetav1.ListOptions{})
+	if err == nil {
+		for i := range dvList.Items {
+			sources.dataVolumes[dvList.Items[i].Name] = &dvList.Items[i]
+		}
+		sources.allDataVolumes = true
+	}
+	return sources, nil
+}
+
+// getVMStatusSources fetches the status sources of vm alone.
+func getVMStatusSources(client kubecli.KubevirtClient, vm *v1.VirtualMachine) *vmStatusSources {
+	sources := newVMStatusSources()
+	if vm.Status.Created {
+		vmi, err := client.VirtualMachineInstance(vm.Namespace).Get(vm.Name, &k8smetav1.GetOptions{})
+		if err == nil {
+			sources.instances[vm.Name] = vmi
+			podList, err := client.CoreV1().Pods(vm.Namespace).List(k8smetav1.ListOptions{
+				LabelSelector: v1.CreatedByLabel + "=" + string(vmi.UID),
+			})
+			if err == nil {
+				sources.addPods(podList.Items)
+			}
+		}
+	}
+	for _, name := range vmDataVolumes(vm) {
+		dv, err := client.CdiClient().CdiV1alpha1().DataVolumes(vm.Namespace).Get(name, k8smetav1.GetOptions{})
+		if err == nil {
+			sources.dataVolumes[name] = dv
+		} else if k8serrors.IsNotFound(err) {
+			sources.dataVolumes[name] = nil
+		}
+	}
+	return sources
+}
+
+func (s *vmStatusSources) addPods(pods []k8sv1.Pod) {
+	for i := range pods {
+		pod := &pods[i]
+		uid := types.UID(pod.Labels[v1.CreatedByLabel])
+		if newest, ok := s.pods[uid]; !ok || newest.CreationTimestamp.Before(&pod.CreationTimestamp) {
+			s.pods[uid] = pod
+		}
+	}
+}
+
+// vmStatus is the status of a VM with the reason and message explaining it.
+type vmStatus struct {
+	status  string
+	reason  string
+	message string
+}
+
+// status derives the status of vm from its conditions and those of its instance, the
+// virt-launcher pod of the instance and the DataVolumes of vm.
+func (s *vmStatusSources) status(vm *v1.VirtualMachine) vmStatus {
+	if vm.DeletionTimestamp != nil {
+		return vmStatus{status: models.VMStatusTerminating}
+	}
+	runStrategy, _ := vm.RunStrategy()
+	vmi := s.instances[vm.Name]
+	if vmi == nil {
+		if status, ok := s.dataVolumeStatus(vm); ok {
+			return status
+		}
+		if condition := vmCondition(vm, v1.VirtualMachineFailure); condition != nil && condition.Status == k8sv1.ConditionTrue {
+			return vmStatus{models.VMStatusFailed, condition.Reason, condition.Message}
+		}
+		if runStrategy == v1.RunStrategyAlways || runStrategy == v1.RunStrategyRerunOnFailure ||
+			hasStateChangeRequest(vm, v1.StartRequest) {
+			return vmStatus{status: models.VMStatusStarting}
+		}
+		return vmStatus{status: models.VMStatusStopped}
+	}
+
+	switch vmi.Status.Phase {
+	case v1.Succeeded:
+		return vmStatus{status: models.VMStatusStopped}
+	case v1.Failed:
+		return vmStatus{models.VMStatusFailed, vmi.Status.Reason, ""}
+	}
+	if vmi.DeletionTimestamp != nil || runStrategy == v1.RunStrategyHalted || hasStateChangeRequest(vm, v1.StopRequest) {
+		return vmStatus{status: models.VMStatusStopping}
+	}
+	if migration := vmi.Status.MigrationState; migration != nil && !migration.Completed && !migration.Failed {
+		return vmStatus{models.VMStatusMigrating, "", fmt.Sprintf("Migrating from %s to %s.", migration.SourceNode, migration.TargetNode)}
+	}
+	if condition := vmiCondition(vmi, v1.VirtualMachineInstancePaused); condition != nil && condition.Status == k8sv1.ConditionTrue {
+		return vmStatus{models.VMStatusPaused, condition.Reason, condition.Message}
+	}
+
+	switch vmi.Status.Phase {
+	case v1.Running:
+		return vmStatus{status: models.VMStatusRunning}
+	case v1.VmPhaseUnset, v1.Pending, v1.Scheduling, v1.Scheduled:
+		return s.startingStatus(vm, vmi)
+	}
+	return vmStatus{models.VMStatusUnknown, vmi.Status.Reason, ""}
+}
+
+// startingStatus tells why the instance vmi of vm is not running yet.
+func (s *vmStatusSources) startingStatus(vm *v1.VirtualMachine, vmi *v1.VirtualMachineInstance) vmStatus {
+	if condition := vmiCondition(vmi, v1.VirtualMachineInstanceConditionType(k8sv1.PodScheduled)); condition != nil &&
+		condition.Status == k8sv1.ConditionFalse && condition.Reason == k8sv1.PodReasonUnschedulable {
+		return vmStatus{models.VMStatusErrorUnschedulable, condition.Reason, condition.Message}
+	}
+	if pod := s.pods[vmi.UID]; pod != nil {
+		for _, container := range pod.Status.ContainerStatuses {
+			if waiting := container.State.Waiting; waiting != nil {
+				switch waiting.Reason {
+				case models.VMStatusErrImagePull, models.VMStatusImagePullBackOff, models.VMStatusCrashLoopBackOff:
+					return vmStatus{waiting.Reason, waiting.Reason, waiting.Message}
+				}
+			}
+		}
+	}
+	if status, ok := s.dataVolumeStatus(vm); ok {
+		return status
+	}
+	if condition := vmiCondition(vmi, v1.VirtualMachineInstanceSynchronized); condition != nil && condition.Status == k8sv1.ConditionFalse {
+		return vmStatus{models.VMStatusStarting, condition.Reason, condition.Message}
+	}
+	return vmStatus{status: models.VMStatusStarting}
+}
+
+// dataVolumeStatus tells whether a DataVolume of vm is failed or still being populated,
+// and the status of vm it implies.
+func (s *vmStatusSources) dataVolumeStatus(vm *v1.VirtualMachine) (vmStatus, bool) {
+	for _, name := range vmDataVolumes(vm) {
+		dv, ok := s.dataVolumes[name]
+		if !ok && !s.allDataVolumes {
+			// Unknown, the DataVolume could not be read
+			continue
+		}
+		switch {
+		case dv == nil && hasDataVolumeTemplate(vm, name):
+			return vmStatus{models.VMStatusProvisioning, "", fmt.Sprintf("DataVolume %s is being created.", name)}, true
+		case dv == nil:
+			return vmStatus{models.VMStatusDataVolumeError, "DataVolumeNotFound", fmt.Sprintf("DataVolume %s does not exist.", name)}, true
+		case dv.Status.Phase == cdiv1.Failed:
+			return vmStatus{models.VMStatusDataVolumeError, string(dv.Status.Phase), fmt.Sprintf("DataVolume %s failed.", name)}, true
+		case dv.Status.Phase != cdiv1.Succeeded:
+			message := fmt.Sprintf("DataVolume %s is %s.", name, dv.Status.Phase)
+			if dv.Status.Progress != "" && dv.Status.Progress != "N/A" {
+				message = fmt.Sprintf("DataVolume %s is %s, %s done.", name, dv.Status.Phase, dv.Status.Progress)
+			}
+			return vmStatus{models.VMStatusProvisioning, string(dv.Status.Phase), message}, true
+		}
+	}
+	return vmStatus{}, false
+}
+
+// vmDataVolumes returns the names of the DataVolumes the volumes of vm refer to.
+func vmDataVolumes(vm *v1.VirtualMachine) []string {
+	var names []string
+	if vm.Spec.Template == nil {
+		return names
+	}
+	for _, volume := range vm.Spec.Template.Spec.Volumes {
+		if volume.DataVolume != nil {
+			names = append(names, volume.DataVolume.Name)
+		}
+	}
+	return names
+}
+
+func hasDataVolumeTemplate(vm *v1.VirtualMachine, name string) bool {
+	return dataVolumeTemplate(vm, name) != nil
+}
+
+// dataVolumeTemplate returns the DataVolumeTemplate of vm creating the DataVolume name, nil if it has none.
+func dataVolumeTemplate(vm *v1.VirtualMachine, name string) *cdiv1.DataVolume {
+	for i := range vm.Spec.DataVolumeTemplates {
+		if vm.Spec.DataVolumeTemplates[i].Name == name {
+			return &vm.Spec.DataVolumeTemplates[i]
+		}
+	}
+	return nil
+}
+
+// hasStateChangeRequest tells whether action is pending on vm.
+func hasStateChangeRequest(vm *v1.VirtualMachine, action v1.StateChangeRequestAction) bool {
+	for _, request := range vm.Status.StateChangeRequests {
+		if request.Action == action {
+			return true
+		}
+	}
+	return false
+}
+
+// vmCondition returns the condition of type conditionType of vm, nil if it has none.
+func vmCondition(vm *v1.VirtualMachine, conditionType v1.VirtualMachineConditionType) *v1.VirtualMachineCondition {
+	for i := range vm.Status.Conditions {
+		if vm.Status.Conditions[i].Type == conditionType {
+			return &vm.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// vmiCondition returns the condition of type conditionType of vmi, nil if it has none.
+func vmiCondition(vmi *v1.VirtualMachineInstance, conditionType v1.VirtualMachineInstanceConditionType) *v1.VirtualMachineInstanceCondition {
+	for i := range vmi.Status.Conditions {
+		if vmi.Status.Conditions[i].Type == conditionType {
+			return &vmi.Status.Conditions[i]
+		}
+	}
+	return nil
+}
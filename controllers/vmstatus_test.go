@@ -0,0 +1,103 @@
+package controllers
+
+import (
+	"testing"
+	"virt-webui/models"
+
+	k8sv1 "k8s.io/api/core/v1"
+	k8smetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1 "kubevirt.io/client-go/api/v1"
+	cdiv1 "kubevirt.io/containerized-data-importer/pkg/apis/core/v1alpha1"
+)
+
+func TestVMStatus(t *testing.T) {
+	newVM := func(running bool) *v1.VirtualMachine {
+		return &v1.VirtualMachine{
+			ObjectMeta: k8smetav1.ObjectMeta{Name: "vm", Namespace: "ns"},
+			Spec: v1.VirtualMachineSpec{
+				Running: &running,
+				Template: &v1.VirtualMachineInstanceTemplateSpec{
+					Spec: v1.VirtualMachineInstanceSpec{
+						Volumes: []v1.Volume{{
+							Name:         "dvdisk",
+							VolumeSource: v1.VolumeSource{DataVolume: &v1.DataVolumeSource{Name: "disk"}},
+						}},
+					},
+				},
+			},
+		}
+	}
+	newVMI := func(phase v1.VirtualMachineInstancePhase, conditions ...v1.VirtualMachineInstanceCondition) *v1.VirtualMachineInstance {
+		return &v1.VirtualMachineInstance{
+			ObjectMeta: k8smetav1.ObjectMeta{Name: "vm", Namespace: "ns", UID: "uid"},
+			Status:     v1.VirtualMachineInstanceStatus{Phase: phase, Conditions: conditions},
+		}
+	}
+	newDV := func(phase cdiv1.DataVolumePhase) *cdiv1.DataVolume {
+		return &cdiv1.DataVolume{
+			ObjectMeta: k8smetav1.ObjectMeta{Name: "disk", Namespace: "ns"},
+			Status:     cdiv1.DataVolumeStatus{Phase: phase, Progress: "42.0%"},
+		}
+	}
+	waitingPod := func(reason string) *k8sv1.Pod {
+		return &k8sv1.Pod{
+			ObjectMeta: k8smetav1.ObjectMeta{Labels: map[string]string{v1.CreatedByLabel: "uid"}},
+			Status: k8sv1.PodStatus{ContainerStatuses: []k8sv1.ContainerStatus{{
+				State: k8sv1.ContainerState{Waiting: &k8sv1.ContainerStateWaiting{Reason: reason, Message: "back-off"}},
+			}}},
+		}
+	}
+
+	for _, c := range []struct {
+		name   string
+		vm     *v1.VirtualMachine
+		vmi    *v1.VirtualMachineInstance
+		pod    *k8sv1.Pod
+		dv     *cdiv1.DataVolume
+		status string
+		reason string
+	}{
+		{name: "stopped", vm: newVM(false), dv: newDV(cdiv1.Succeeded), status: models.VMStatusStopped},
+		{name: "starting", vm: newVM(true), dv: newDV(cdiv1.Succeeded), status: models.VMStatusStarting},
+		{name: "importing", vm: newVM(true), dv: newDV(cdiv1.ImportInProgress),
+			status: models.VMStatusProvisioning, reason: string(cdiv1.ImportInProgress)},
+		{name: "import failed", vm: newVM(false), dv: newDV(cdiv1.Failed),
+			status: models.VMStatusDataVolumeError, reason: string(cdiv1.Failed)},
+		{name: "disk missing", vm: newVM(true), status: models.VMStatusDataVolumeError, reason: "DataVolumeNotFound"},
+		{name: "running", vm: newVM(true), vmi: newVMI(v1.Running), dv: newDV(cdiv1.Succeeded), status: models.VMStatusRunning},
+		{name: "stopping", vm: newVM(false), vmi: newVMI(v1.Running), dv: newDV(cdiv1.Succeeded), status: models.VMStatusStopping},
+		{name: "paused", vm: newVM(true), dv: newDV(cdiv1.Succeeded),
+			vmi: newVMI(v1.Running, v1.VirtualMachineInstanceCondition{
+				Type: v1.VirtualMachineInstancePaused, Status: k8sv1.ConditionTrue, Reason: "PausedByUser",
+			}),
+			status: models.VMStatusPaused, reason: "PausedByUser"},
+		{name: "unschedulable", vm: newVM(true), dv: newDV(cdiv1.Succeeded),
+			vmi: newVMI(v1.Scheduling, v1.VirtualMachineInstanceCondition{
+				Type:   v1.VirtualMachineInstanceConditionType(k8sv1.PodScheduled),
+				Status: k8sv1.ConditionFalse, Reason: k8sv1.PodReasonUnschedulable,
+			}),
+			status: models.VMStatusErrorUnschedulable, reason: k8sv1.PodReasonUnschedulable},
+		{name: "image pull", vm: newVM(true), vmi: newVMI(v1.Scheduled), pod: waitingPod("ImagePullBackOff"),
+			dv: newDV(cdiv1.Succeeded), status: models.VMStatusImagePullBackOff, reason: "ImagePullBackOff"},
+		{name: "crash loop", vm: newVM(true), vmi: newVMI(v1.Scheduled), pod: waitingPod("CrashLoopBackOff"),
+			dv: newDV(cdiv1.Succeeded), status: models.VMStatusCrashLoopBackOff, reason: "CrashLoopBackOff"},
+		{name: "guest shut down", vm: newVM(true), vmi: newVMI(v1.Succeeded), dv: newDV(cdiv1.Succeeded), status: models.VMStatusStopped},
+		{name: "failed", vm: newVM(true), vmi: newVMI(v1.Failed), dv: newDV(cdiv1.Succeeded), status: models.VMStatusFailed},
+	} {
+		sources := newVMStatusSources()
+		sources.allDataVolumes = true
+		if c.vmi != nil {
+			sources.instances[c.vmi.Name] = c.vmi
+		}
+		if c.pod != nil {
+			sources.addPods([]k8sv1.Pod{*c.pod})
+		}
+		if c.dv != nil {
+			sources.dataVolumes[c.dv.Name] = c.dv
+		}
+		status := sources.status(c.vm)
+		if status.status != c.status || status.reason != c.reason {
+			t.Errorf("%s: got %s (%s), expected %s (%s)", c.name, status.status, status.reason, c.status, c.reason)
+		}
+	}
+}
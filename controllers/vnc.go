@@ -0,0 +1,41 @@
+package controllers
+
+import (
+	"github.com/astaxie/beego"
+)
+
+// @Title VNC Console
+// @Description Open a websocket to the VNC console of a running virtual machine, for noVNC. Browsers authenticate with their cookies, basic auth, or a bearer token in a base64url.bearer.authorization.k8s.io.<token> subprotocol. The websocket is closed after the consoleidletimeout of app.conf without any traffic.
+// @Param	VMName	path	string	true	"The VM you want to see"
+// @Success 101 Switching protocols to the websocket.
+// @Failure 403 Forbidden by the RBAC rules of the cluster.
+// @Failure 404 VM not running.
+// @Failure 500 Failed to connect to VNC.
+// @router /:VMName/vnc [get]
+func (v *VMController) VNC() {
+	v.EnableRender = false
+	namespace, virtClient, err := v.GetVirtClient()
+	if err != nil {
+		v.ResponseNotAvaliable(err)
+		return
+	}
+
+	vmName := v.Ctx.Input.Param(":VMName")
+	// Connect first, so failures are reported as JSON rather than as a closed websocket
+	stream, err := (*virtClient).VirtualMachineInstance(*namespace).VNC(vmName)
+	if err != nil {
+		v.SetError("Failed to connect to VNC of "+vmName+".", err)
+		v.ServeJSON()
+		return
+	}
+
+	conn, err := wsUpgrader.Upgrade(v.Ctx.ResponseWriter, v.Ctx.Request, nil)
+	if err != nil {
+		// The upgrader replied already
+		closeStream(stream)
+		return
+	}
+	beego.Info("VNC of", *namespace+"/"+vmName, "opened for", v.userName())
+	err = bridgeStream(conn, stream, consoleIdleTimeout())
+	beego.Info("VNC of", *namespace+"/"+vmName, "closed for", v.userName()+":", err)
+}
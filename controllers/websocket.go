@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/gorilla/websocket"
+	"kubevirt.io/client-go/kubecli"
+)
+
+// wsPingInterval keeps the websockets of idle consoles open through proxies.
+const wsPingInterval = 30 * time.Second
+
+// wsUpgrader accepts websockets from the origin of the dashboard only, the cookies of
+// browsers authenticating them from any origin. The binary subprotocol is the one of
+// noVNC, the token subprotocol of the auth package is never selected.
+var wsUpgrader = websocket.Upgrader{
+	Subprotocols: []string{"binary"},
+}
+
+// consoleIdleTimeout returns the consoleidletimeout of app.conf, 0 disabling it.
+func consoleIdleTimeout() time.Duration {
+	timeout, err := time.ParseDuration(beego.AppConfig.DefaultString("consoleidletimeout", "15m"))
+	if err != nil {
+		beego.Warning("invalid consoleidletimeout:", err)
+		return 15 * time.Minute
+	}
+	return timeout
+}
+
+// closeStream ends stream when the websocket it was opened for cannot be.
+func closeStream(stream kubecli.StreamInterface) {
+	stream.Stream(kubecli.StreamOptions{In: strings.NewReader(""), Out: ioutil.Discard})
+}
+
+// wsConn adapts a websocket to the io.Reader and io.Writer of a KubeVirt stream, and tracks
+// the last time data went either way.
+type wsConn struct {
+	// lastActive is the UnixNano time of the last message, first for 64-bit alignment
+	lastActive int64
+	conn       *websocket.Conn
+	reader     io.Reader
+	writeMutex sync.Mutex
+}
+
+func newWSConn(conn *websocket.Conn) *wsConn {
+	c := &wsConn{conn: conn}
+	c.active()
+	return c
+}
+
+func (c *wsConn) active() {
+	atomic.StoreInt64(&c.lastActive, time.Now().UnixNano())
+}
+
+func (c *wsConn) idle() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&c.lastActive)))
+}
+
+// Read reads the data of the text and binary messages of the websocket.
+func (c *wsConn) Read(p []byte) (int, error) {
+	for {
+		if c.reader == nil {
+			_, reader, err := c.conn.NextReader()
+			if err != nil {
+				return 0, err
+			}
+			c.active()
+			c.reader = reader
+		}
+		n, err := c.reader.Read(p)
+		if err == io.EOF {
+			c.reader = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+// Write sends p as a binary message.
+func (c *wsConn) Write(p []byte) (int, error) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	c.active()
+	if err := c.conn.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// close closes the websocket, telling the client why.
+func (c *wsConn) close(code int, reason string) {
+	c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+	c.conn.Close()
+}
+
+// keepAlive pings the client until done is closed, and closes the websocket once no data
+// went either way for idleTimeout, unless it is 0.
+func (c *wsConn) keepAlive(idleTimeout time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+	var idleTimer <-chan time.Time
+	if idleTimeout > 0 {
+		timer := time.NewTimer(idleTimeout)
+		defer timer.Stop()
+		idleTimer = timer.C
+	}
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsPingInterval)); err != nil {
+				c.conn.Close()
+				return
+			}
+		case <-idleTimer:
+			if idle := c.idle(); idle < idleTimeout {
+				idleTimer = time.After(idleTimeout - idle)
+				continue
+			}
+			c.close(websocket.ClosePolicyViolation, "Idle timeout.")
+			return
+		}
+	}
+}
+
+// bridgeStream copies the data of conn to stream and back until either side closes or
+// the connection is idle for idleTimeout, then closes conn.
+func bridgeStream(conn *websocket.Conn, stream kubecli.StreamInterface, idleTimeout time.Duration) error {
+	c := newWSConn(conn)
+	done := make(chan struct{})
+	go c.keepAlive(idleTimeout, done)
+	err := stream.Stream(kubecli.StreamOptions{In: c, Out: c})
+	close(done)
+	c.close(websocket.CloseNormalClosure, "")
+	return err
+}
@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"kubevirt.io/client-go/kubecli"
+)
+
+// echoStream is a KubeVirt stream sending back what it receives.
+type echoStream struct{}
+
+func (echoStream) Stream(options kubecli.StreamOptions) error {
+	_, err := io.Copy(options.Out, options.In)
+	return err
+}
+
+func dialBridge(t *testing.T, idleTimeout time.Duration) (*websocket.Conn, func()) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := wsUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		bridgeStream(conn, echoStream{}, idleTimeout)
+	}))
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	return conn, func() {
+		conn.Close()
+		server.Close()
+	}
+}
+
+func TestBridgeStream(t *testing.T) {
+	conn, done := dialBridge(t, 0)
+	defer done()
+	for _, message := range []string{"RFB 003.008\n", "more"} {
+		if err := conn.WriteMessage(websocket.BinaryMessage, []byte(message)); err != nil {
+			t.Fatal(err)
+		}
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if messageType != websocket.BinaryMessage || string(data) != message {
+			t.Errorf("got %d %q, expected %q", messageType, data, message)
+		}
+	}
+}
+
+func TestBridgeStreamIdleTimeout(t *testing.T) {
+	conn, done := dialBridge(t, 100*time.Millisecond)
+	defer done()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err := conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Errorf("got %v, expected an idle timeout close", err)
+	}
+}
@@ -4,9 +4,16 @@ go 1.15
 
 require (
 	github.com/astaxie/beego v1.12.2
-	github.com/mattn/go-runewidth v0.0.9 // indirect
+	github.com/coreos/go-oidc v2.2.1+incompatible
+	github.com/go-sql-driver/mysql v1.5.0
+	github.com/gorilla/websocket v1.4.0
+	github.com/pkg/errors v0.9.1 // indirect
+	github.com/pquerna/cachecontrol v0.1.0 // indirect
 	github.com/spf13/pflag v1.0.5
-	gopkg.in/cheggaaa/pb.v1 v1.0.28
+	golang.org/x/crypto v0.0.0-20191011191535-87dc89f01550
+	golang.org/x/oauth2 v0.0.0-20190604053449-0f29369cfe45
+	gopkg.in/square/go-jose.v2 v2.6.0
+	gopkg.in/yaml.v2 v2.2.8
 	k8s.io/api v0.17.0
 	k8s.io/apimachinery v0.17.1-beta.0
 	k8s.io/client-go v12.0.0+incompatible
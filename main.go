@@ -1,12 +1,29 @@
 package main
 
 import (
+	"log"
+	"virt-webui/controllers"
+	"virt-webui/controllers/audit"
+	"virt-webui/controllers/auth"
 	_ "virt-webui/routers"
 
 	"github.com/astaxie/beego"
 )
 
 func main() {
+	if err := controllers.LoadFlavors(beego.AppConfig.DefaultString("flavorsfile", "conf/flavors.json")); err != nil {
+		log.Fatalf("cannot load flavors: %v\n", err)
+	}
+	if err := controllers.LoadClusters(beego.AppConfig.String("kubeconfig"), beego.AppConfig.String("kubecontext"),
+		beego.AppConfig.String("clustersfile")); err != nil {
+		log.Fatalf("cannot load clusters: %v\n", err)
+	}
+	if err := auth.Init(); err != nil {
+		log.Fatalf("cannot set up authentication: %v\n", err)
+	}
+	if err := audit.Init(); err != nil {
+		log.Fatalf("cannot set up the audit log: %v\n", err)
+	}
 	if beego.BConfig.RunMode == "dev" {
 		beego.BConfig.WebConfig.DirectoryIndex = true
 		beego.BConfig.WebConfig.StaticDir["/swagger"] = "swagger"
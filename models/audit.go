@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// AuditEntry records a mutating call of the API, or a session on the serial console of a VM.
+type AuditEntry struct {
+	Time time.Time
+	// User is the authenticated user, empty without authentication
+	User       string
+	RemoteAddr string
+	Cluster    string
+	Namespace  string
+	// Resource is VM or Image, Action the handler, e.g. Start or Delete
+	Resource string
+	Action   string
+	// Target is the name of the VM or image acted on
+	Target string
+	// BodyDigest is the hex SHA-256 of the request body, empty if it had none
+	BodyDigest string
+	StatusCode int
+	// Result is success or failure, Error the message of a failure
+	Result string
+	Error  string
+	// Transcript is the output of a serial console while the user could type in it,
+	// when consoletranscript is enabled in app.conf
+	Transcript string `json:",omitempty"`
+}
@@ -0,0 +1,17 @@
+package models
+
+// Cluster is a Kubernetes cluster running KubeVirt managed by virt-webui.
+type Cluster struct {
+	Name string
+	// Context of the kubeconfig the cluster is reached with
+	Context string
+	// Server is the URL of the API server
+	Server string
+	// Namespace is the namespace of the routes outside /v1/clusters/:cluster/namespaces
+	Namespace string
+	// Default is set for the cluster of the routes outside /v1/clusters
+	Default bool
+	// Available is unset when no client could be built for the cluster, Message tells why
+	Available bool
+	Message   string
+}
@@ -0,0 +1,16 @@
+package models
+
+// Disk is a disk of a VM and the volume backing it.
+type Disk struct {
+	Name string
+	// Type is Disk, CDROM, LUN or Floppy
+	Type     string
+	Bus      string
+	ReadOnly bool
+	// DataVolume or PVC backing the disk, empty for the other volume types
+	DataVolume string `json:",omitempty"`
+	PVC        string `json:",omitempty"`
+	// Image the disk was cloned from or is, when known
+	Image string `json:",omitempty"`
+	Size  string `json:",omitempty"`
+}
@@ -0,0 +1,16 @@
+package models
+
+// Flavor is a named size of virtual machine.
+type Flavor struct {
+	Name    string
+	Cores   uint32
+	Sockets uint32
+	Threads uint32
+	// Memory requested by the VM, e.g. 2Gi
+	Memory string
+	// CPULimit and MemoryLimit are optional limits, e.g. 2 and 4Gi
+	CPULimit    string
+	MemoryLimit string
+	// HugepagesPageSize backs the memory of the VM with hugepages, e.g. 2Mi or 1Gi
+	HugepagesPageSize string
+}
@@ -1,6 +1,44 @@
 package models
 
+import "time"
+
 type Image struct {
-	Name string
-	Namespace string
+	Name              string
+	Namespace         string
+	Phase             string
+	Progress          string
+	RequestedCapacity string
+	Capacity          string
+	StorageClass      string
+	AccessModes       []string
+	VolumeMode        string
+	CreationTime      time.Time
+	SourceType        string
+	// VMs are the virtual machines using the image as a volume
+	VMs []string
+}
+
+// ImageJob is the state of an asynchronous image upload.
+type ImageJob struct {
+	ID         string
+	Name       string
+	Source     string
+	Phase      string
+	Message    string
+	BytesSent  int64
+	TotalBytes int64
+	Throughput float64 // bytes per second while transferring
+	ETASeconds float64 // -1 if unknown
+	StartTime  time.Time
+	UpdateTime time.Time
+}
+
+// ImageImport is the state of an image imported from a URL.
+type ImageImport struct {
+	Name       string
+	Namespace  string
+	SourceType string
+	URL        string
+	Phase      string
+	Progress   string
 }
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// Migration is the state of a live migration of a VM to another node.
+type Migration struct {
+	Name      string
+	Namespace string
+	VM        string
+	Phase     string
+	// The nodes and times are known once the migration started
+	SourceNode string
+	TargetNode string
+	StartTime  *time.Time `json:",omitempty"`
+	EndTime    *time.Time `json:",omitempty"`
+	// ElapsedSeconds is the time the migration has been running, or took
+	ElapsedSeconds float64
+	// AbortStatus is Aborting, Succeeded or Failed once the migration is cancelled
+	AbortStatus  string `json:",omitempty"`
+	CreationTime time.Time
+}
@@ -0,0 +1,6 @@
+package models
+
+type Namespace struct {
+	Name   string
+	Status string
+}
@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// The ways a snapshot of a VM is taken
+const (
+	// SnapshotMethodKubeVirt snapshots are KubeVirt VirtualMachineSnapshots
+	SnapshotMethodKubeVirt = "KubeVirt"
+	// SnapshotMethodClone snapshots are CDI clones of the DataVolumes of a stopped VM
+	SnapshotMethodClone = "Clone"
+)
+
+// Snapshot is a copy of the disks of a VM at one point in time, the VM can be restored to.
+type Snapshot struct {
+	Name      string
+	Namespace string
+	VM        string
+	Method    string
+	// Ready tells whether the VM can be restored to the snapshot
+	Ready bool
+	// Error tells why the snapshot failed
+	Error string `json:",omitempty"`
+	// Volumes are the volumes of the VM in the snapshot
+	Volumes      []string
+	CreationTime time.Time
+}
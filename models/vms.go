@@ -1,9 +1,42 @@
 package models
 
+// The values of VM.Status.
+const (
+	VMStatusStopped      = "Stopped"
+	VMStatusProvisioning = "Provisioning"
+	VMStatusStarting     = "Starting"
+	VMStatusRunning      = "Running"
+	VMStatusPaused       = "Paused"
+	VMStatusStopping     = "Stopping"
+	VMStatusTerminating  = "Terminating"
+	VMStatusMigrating    = "Migrating"
+	VMStatusFailed       = "Failed"
+	VMStatusUnknown      = "Unknown"
+	// The VM cannot be scheduled on any node
+	VMStatusErrorUnschedulable = "ErrorUnschedulable"
+	// The container disk or launcher image of the VM cannot be pulled
+	VMStatusErrImagePull     = "ErrImagePull"
+	VMStatusImagePullBackOff = "ImagePullBackOff"
+	// The launcher of the VM keeps crashing
+	VMStatusCrashLoopBackOff = "CrashLoopBackOff"
+	// A DataVolume of the VM failed to be populated
+	VMStatusDataVolumeError = "DataVolumeError"
+)
+
 type VM struct {
 	Name      string
 	Namespace string
 	IP        string
-	Size      int
-	Status    string
+	// Flavor the VM was created from, empty for explicit CPU and memory
+	Flavor  string
+	Cores   uint32
+	Sockets uint32
+	Threads uint32
+	Memory  string
+	Status  string
+	// Node runs the VM, empty when it is stopped
+	Node string
+	// StatusReason and StatusMessage explain a Status other than Running or Stopped, when known
+	StatusReason  string `json:",omitempty"`
+	StatusMessage string `json:",omitempty"`
 }
@@ -13,8 +13,10 @@ import (
 	"github.com/astaxie/beego"
 )
 
-func init() {
-	ns := beego.NewNamespace("/v1",
+// clusterRoutes are the routes acting on the resources of one cluster. Under /v1 they
+// act on the default cluster, under /v1/clusters/:cluster on :cluster.
+func clusterRoutes() []beego.LinkNamespace {
+	return []beego.LinkNamespace{
 		beego.NSNamespace("/images",
 			beego.NSInclude(
 				&controllers.ImageController{},
@@ -25,6 +27,60 @@ func init() {
 				&controllers.VMController{},
 			),
 		),
+		beego.NSNamespace("/migrations",
+			beego.NSInclude(
+				&controllers.MigrationController{},
+			),
+		),
+		beego.NSNamespace("/namespaces",
+			beego.NSInclude(
+				&controllers.NamespaceController{},
+			),
+			beego.NSNamespace("/:ns",
+				beego.NSNamespace("/images",
+					beego.NSInclude(
+						&controllers.ImageController{},
+					),
+				),
+				beego.NSNamespace("/vms",
+					beego.NSInclude(
+						&controllers.VMController{},
+					),
+				),
+				beego.NSNamespace("/migrations",
+					beego.NSInclude(
+						&controllers.MigrationController{},
+					),
+				),
+			),
+		),
+	}
+}
+
+func init() {
+	ns := beego.NewNamespace("/v1",
+		append(clusterRoutes(),
+			beego.NSNamespace("/flavors",
+				beego.NSInclude(
+					&controllers.FlavorController{},
+				),
+			),
+			beego.NSNamespace("/audit",
+				beego.NSInclude(
+					&controllers.AuditController{},
+				),
+			),
+			beego.NSNamespace("/clusters",
+				beego.NSInclude(
+					&controllers.ClusterController{},
+				),
+				beego.NSNamespace("/:cluster", clusterRoutes()...),
+			),
+		)...,
 	)
 	beego.AddNamespace(ns)
+	for _, prefix := range []string{"/v1", "/v1/clusters/:cluster"} {
+		beego.InsertFilter(prefix+"/images/upload", beego.BeforeStatic, controllers.StreamRequestBody)
+		beego.InsertFilter(prefix+"/namespaces/:ns/images/upload", beego.BeforeStatic, controllers.StreamRequestBody)
+	}
 }
@@ -7,18 +7,35 @@ const Overview_template = {
         }
     },
     methods: {
-        getImages: function () {
-            axios.get("/v1/images/").then((response) => {
+        getClusters: function () {
+            axios.get("/v1/clusters/").then((response) => {
                 console.log(response)
-                this.imgList = response.data.Images
+                this.imgList = []
+                this.vmList = []
+                response.data.Clusters.filter((cluster) => cluster.Available).forEach((cluster) => {
+                    this.getImages(cluster)
+                    this.getVMs(cluster)
+                })
             }, (err) => {
                 console.log(err)
             })
         },
-        getVMs: function () {
-            axios.get("/v1/vms/").then((response) => {
+        // tag marks the items of a cluster with the cluster they belong to
+        tag: function (items, cluster) {
+            return (items || []).map((item) => Object.assign(item, { Cluster: cluster.Name, DefaultCluster: cluster.Default }))
+        },
+        getImages: function (cluster) {
+            axios.get("/v1/clusters/" + cluster.Name + "/images/").then((response) => {
+                console.log(response)
+                this.imgList = this.imgList.concat(this.tag(response.data.Images, cluster))
+            }, (err) => {
+                console.log(err)
+            })
+        },
+        getVMs: function (cluster) {
+            axios.get("/v1/clusters/" + cluster.Name + "/vms/").then((response) => {
                 console.log(response)
-                this.vmList = response.data.VMs
+                this.vmList = this.vmList.concat(this.tag(response.data.VMs, cluster))
             }, (err) => {
                 console.log(err)
             })
@@ -29,7 +46,6 @@ const Overview_template = {
     },
     mounted() {
         this.setMenuOption()
-        this.getImages()
-        this.getVMs()
+        this.getClusters()
     }
-}
\ No newline at end of file
+}
@@ -2,7 +2,10 @@ const VMs_detail_template = {
     template: "#vms_detail_template",
     data() {
         return {
-            vm: {}
+            vm: {},
+            snapshotList: [],
+            diskList: [],
+            diskMessage: ''
         }
     },
     methods: {
@@ -14,6 +17,81 @@ const VMs_detail_template = {
                 console.log(err)
             })
         },
+        getDisks: function () {
+            axios.get("/v1/vms/" + this.$route.params.name + "/disks").then((response) => {
+                console.log(response)
+                this.diskList = response.data.Disks
+            }, (err) => {
+                console.log(err)
+            })
+        },
+        addDisk: function () {
+            var name = prompt("Name of the new disk")
+            var size = name && prompt("Size of " + name, "10Gi")
+            if (!size) {
+                return
+            }
+            this.updateDisks(axios.post("/v1/vms/" + this.$route.params.name + "/disks", { "Name": name, "Size": size }))
+        },
+        attachDisk: function () {
+            var image = prompt("Image to attach")
+            var name = image && prompt("Name of the disk of " + image)
+            if (!name) {
+                return
+            }
+            var data = { "Name": name, "Image": image }
+            if (confirm("Attach " + image + " as a CD-ROM?")) {
+                data.Type = "CDROM"
+            } else {
+                data.Writable = confirm("Attach " + image + " writable? No other VM may use it then.")
+            }
+            this.updateDisks(axios.post("/v1/vms/" + this.$route.params.name + "/disks/attach", data))
+        },
+        detachDisk: function (name) {
+            this.updateDisks(axios.delete("/v1/vms/" + this.$route.params.name + "/disks/" + name))
+        },
+        updateDisks: function (request) {
+            request.then((res) => {
+                console.log(res)
+                this.diskMessage = res.data.RestartRequired ? res.data.Message : ''
+                this.getDisks()
+            }, (err) => {
+                console.log(err)
+                this.diskMessage = err.response ? err.response.data.Message : ''
+            })
+        },
+        getSnapshots: function () {
+            axios.get("/v1/vms/" + this.$route.params.name + "/snapshots").then((response) => {
+                console.log(response)
+                this.snapshotList = response.data.Snapshots
+            }, (err) => {
+                console.log(err)
+            })
+        },
+        createSnapshot: function () {
+            axios.post("/v1/vms/" + this.$route.params.name + "/snapshots", {}).then((res) => {
+                console.log(res)
+                this.getSnapshots()
+            }, (err) => {
+                console.log(err)
+            })
+        },
+        deleteSnapshot: function (name) {
+            axios.delete("/v1/vms/" + this.$route.params.name + "/snapshots/" + name).then((res) => {
+                console.log(res)
+                this.getSnapshots()
+            }, (err) => {
+                console.log(err)
+            })
+        },
+        restoreSnapshot: function (name) {
+            axios.post("/v1/vms/" + this.$route.params.name + "/snapshots/" + name + "/restore").then((res) => {
+                console.log(res)
+                this.getVM()
+            }, (err) => {
+                console.log(err)
+            })
+        },
         setMenuOption: function () {
             this.$parent.selectOption(2)
         },
@@ -24,5 +102,7 @@ const VMs_detail_template = {
     mounted() {
         this.setMenuOption()
         this.getVM()
+        this.getDisks()
+        this.getSnapshots()
     }
 }
\ No newline at end of file
@@ -5,7 +5,10 @@ const VMs_template = {
             vmList: [],
             createVMName: '',
             createVMImage: '',
-            createVMSize: 0,
+            createVMFlavor: '',
+            createVMCloneDisk: false,
+            createVMDiskSize: '',
+            flavorList: [],
             vmToDelete: ''
         }
     },
@@ -18,17 +21,30 @@ const VMs_template = {
                 console.log(err)
             })
         },
+        getFlavors: function () {
+            axios.get("/v1/flavors/").then((response) => {
+                console.log(response)
+                this.flavorList = response.data.Flavors
+                this.createVMFlavor = response.data.DefaultFlavor
+            }, (err) => {
+                console.log(err)
+            })
+        },
         setMenuOption: function () {
             this.$parent.selectOption(2)
         },
         createVM: function () {
             console.log(this.createVMName)
             console.log(this.createVMImage)
-            console.log(this.createVMSize)
+            console.log(this.createVMFlavor)
             var data = {
                 "Name": this.createVMName,
                 "Image": this.createVMImage,
-                "Size": parseInt(this.createVMSize),
+                "Flavor": this.createVMFlavor,
+                "DiskMode": this.createVMCloneDisk ? "Clone" : "Attach",
+            }
+            if (this.createVMCloneDisk) {
+                data.DiskSize = this.createVMDiskSize
             }
             axios.post("/v1/vms/", data).then((res) => {
                 console.log(res)
@@ -74,10 +90,48 @@ const VMs_template = {
             }), (err) => {
                 console.log(err)
             }
+        },
+        vmAction: function (index, action) {
+            var vm = this.vmList[index].Name
+            console.log(action + ": " + vm)
+            var data = {
+                "Name": vm
+            }
+            axios.post("/v1/vms/" + action + "/", data).then((res) => {
+                console.log(res)
+                this.getVMs()
+            }, (err) => {
+                console.log(err)
+            })
+        },
+        migrateVM: function (index) {
+            var vm = this.vmList[index].Name
+            console.log("migrate: " + vm)
+            axios.post("/v1/vms/" + vm + "/migrate").then((res) => {
+                console.log(res)
+                this.getVMs()
+            }, (err) => {
+                console.log(err)
+            })
+        },
+        cloneVM: function (index) {
+            var vm = this.vmList[index].Name
+            var newName = prompt("Name of the clone of " + vm)
+            if (!newName) {
+                return
+            }
+            console.log("clone: " + vm + " to " + newName)
+            axios.post("/v1/vms/" + vm + "/clone", { "NewName": newName }).then((res) => {
+                console.log(res)
+                this.getVMs()
+            }, (err) => {
+                console.log(err)
+            })
         }
     },
     mounted() {
         this.setMenuOption()
         this.getVMs()
+        this.getFlavors()
     }
 }
\ No newline at end of file